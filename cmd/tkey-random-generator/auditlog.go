@@ -0,0 +1,305 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tillitis/tkeyclient"
+	"golang.org/x/crypto/blake2s"
+)
+
+// auditVersion is the version of the audit log entry format and of
+// how the entry hash is computed.
+const auditVersion = 1
+
+// auditDomain separates audit log entry hashes from other BLAKE2s
+// hashes.
+const auditDomain = "tkey-random-generator audit v1"
+
+// auditEntry is one line of the audit log: a signature made by a TKey
+// over Bytes of random data, without the data itself. EntryHash is the
+// BLAKE2s hash of all the other fields, see hashAuditEntry, and is what
+// the next entry refers to in Prev, the first one referring to
+// genesisPrev. Firmware is nil if the device app was already running.
+type auditEntry struct {
+	Version   int
+	Seq       uint64
+	Time      time.Time
+	Host      string
+	Command   string
+	Bytes     int64
+	Hash      []byte
+	Signature []byte
+	Pubkey    []byte
+	Nonce     []byte
+	Scheme    int
+	Context   string
+	App       bundleApp
+	Firmware  *bundleApp
+	Prev      []byte
+	EntryHash []byte
+}
+
+// jsonAuditEntry is how an audit log entry is written.
+type jsonAuditEntry struct {
+	Version   int        `json:"version"`
+	Seq       uint64     `json:"seq"`
+	Time      string     `json:"time"`
+	Host      string     `json:"host"`
+	Command   string     `json:"command"`
+	Bytes     int64      `json:"bytes"`
+	Hash      string     `json:"hash"`
+	Signature string     `json:"signature"`
+	Pubkey    string     `json:"pubkey"`
+	Nonce     string     `json:"nonce,omitempty"`
+	Scheme    int        `json:"scheme"`
+	Context   string     `json:"context,omitempty"`
+	App       bundleApp  `json:"app"`
+	Firmware  *bundleApp `json:"firmware,omitempty"`
+	Prev      string     `json:"prev"`
+	EntryHash string     `json:"entry_hash"`
+}
+
+// firmwareApp returns the name and version of firmware, the firmware
+// that loaded the device app, or nil if not known.
+func firmwareApp(firmware *tkeyclient.NameVersion) *bundleApp {
+	if firmware == nil {
+		return nil
+	}
+
+	return &bundleApp{Name: firmware.Name0 + firmware.Name1, Version: firmware.Version}
+}
+
+// appendAudit appends entries to the audit log in path, continuing the
+// chain of the entries already there. The log is locked while doing
+// so, since several processes may use it.
+func appendAudit(path string, entries ...*auditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("could not get host name: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	unlock, err := lockFile(f)
+	if err != nil {
+		return fmt.Errorf("could not lock audit log %s: %w", path, err)
+	}
+	defer unlock()
+
+	line, err := lastLine(f)
+	if err != nil {
+		return fmt.Errorf("could not read audit log %s: %w", path, err)
+	}
+
+	seq, prev := uint64(0), genesisPrev
+	if line != nil {
+		last, err := decodeAuditEntry(line)
+		if err != nil {
+			return fmt.Errorf("last entry in audit log %s: %w", path, err)
+		}
+		seq, prev = last.Seq+1, last.EntryHash
+	}
+
+	var buf bytes.Buffer
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, e := range entries {
+		e.Version = auditVersion
+		e.Seq = seq
+		e.Time = now
+		e.Host = host
+		e.Prev = prev
+		e.EntryHash = hashAuditEntry(e)
+
+		line, err := encodeAuditEntry(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+
+		seq, prev = e.Seq+1, e.EntryHash
+	}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not write audit log %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("could not write audit log %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// hashAuditEntry returns the BLAKE2s hash of all fields of e but the
+// entry hash itself. Strings and binary fields of variable length are
+// prefixed with their length.
+func hashAuditEntry(e *auditEntry) []byte {
+	var buf bytes.Buffer
+
+	field := func(b []byte) {
+		_ = binary.Write(&buf, binary.BigEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+
+	buf.WriteString(auditDomain)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(e.Version))
+	_ = binary.Write(&buf, binary.BigEndian, e.Seq)
+	_ = binary.Write(&buf, binary.BigEndian, e.Time.Unix())
+	field([]byte(e.Host))
+	field([]byte(e.Command))
+	_ = binary.Write(&buf, binary.BigEndian, e.Bytes)
+	field(e.Hash)
+	field(e.Signature)
+	field(e.Pubkey)
+	field(e.Nonce)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(e.Scheme))
+	field([]byte(e.Context))
+	field([]byte(e.App.Name))
+	_ = binary.Write(&buf, binary.BigEndian, e.App.Version)
+	if e.Firmware == nil {
+		buf.WriteByte(0)
+	} else {
+		buf.WriteByte(1)
+		field([]byte(e.Firmware.Name))
+		_ = binary.Write(&buf, binary.BigEndian, e.Firmware.Version)
+	}
+	// Left out if not known, so that entries from before the digest
+	// was recorded hash the same
+	if e.App.SHA512 != "" {
+		field([]byte(e.App.SHA512))
+	}
+	buf.Write(e.Prev)
+
+	sum := blake2s.Sum256(buf.Bytes())
+
+	return sum[:]
+}
+
+// encodeAuditEntry returns e as a line of JSON.
+func encodeAuditEntry(e *auditEntry) ([]byte, error) {
+	line, err := json.Marshal(jsonAuditEntry{
+		Version:   e.Version,
+		Seq:       e.Seq,
+		Time:      e.Time.Format(time.RFC3339),
+		Host:      e.Host,
+		Command:   e.Command,
+		Bytes:     e.Bytes,
+		Hash:      hex.EncodeToString(e.Hash),
+		Signature: hex.EncodeToString(e.Signature),
+		Pubkey:    hex.EncodeToString(e.Pubkey),
+		Nonce:     hex.EncodeToString(e.Nonce),
+		Scheme:    e.Scheme,
+		Context:   e.Context,
+		App:       e.App,
+		Firmware:  e.Firmware,
+		Prev:      hex.EncodeToString(e.Prev),
+		EntryHash: hex.EncodeToString(e.EntryHash),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode audit log entry: %w", err)
+	}
+
+	return line, nil
+}
+
+// decodeAuditEntry parses a line of the audit log.
+func decodeAuditEntry(line []byte) (*auditEntry, error) {
+	var j jsonAuditEntry
+	if err := json.Unmarshal(line, &j); err != nil {
+		return nil, fmt.Errorf("not an audit log entry: %w", err)
+	}
+
+	if j.Version != auditVersion {
+		return nil, fmt.Errorf("unsupported version %d", j.Version)
+	}
+
+	t, err := time.Parse(time.RFC3339, j.Time)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp: %w", err)
+	}
+
+	e := auditEntry{
+		Version:  j.Version,
+		Seq:      j.Seq,
+		Time:     t,
+		Host:     j.Host,
+		Command:  j.Command,
+		Bytes:    j.Bytes,
+		Scheme:   j.Scheme,
+		Context:  j.Context,
+		App:      j.App,
+		Firmware: j.Firmware,
+	}
+
+	fields := []string{j.Hash, j.Signature, j.Pubkey, j.Nonce, j.Prev, j.EntryHash}
+	dst := []*[]byte{&e.Hash, &e.Signature, &e.Pubkey, &e.Nonce, &e.Prev, &e.EntryHash}
+	sizes := []int{blake2s.Size, ed25519.SignatureSize, ed25519.PublicKeySize, -1, blake2s.Size, blake2s.Size}
+	names := []string{"hash", "signature", "public key", "nonce", "previous entry hash", "entry hash"}
+
+	for i := range dst {
+		b, err := hex.DecodeString(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %w", names[i], err)
+		}
+		if sizes[i] > 0 && len(b) != sizes[i] {
+			return nil, fmt.Errorf("%s is %d bytes, expected %d", names[i], len(b), sizes[i])
+		}
+		*dst[i] = b
+	}
+
+	if len(e.Nonce) == 0 {
+		e.Nonce = nil
+	}
+
+	return &e, nil
+}
+
+// lastLine returns the last non-empty line of f, or nil if there is
+// none, reading f from the end.
+func lastLine(f *os.File) ([]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var tail []byte
+	for end := fi.Size(); end > 0; {
+		n := min(end, 4096)
+		chunk := make([]byte, n)
+		if _, err := f.ReadAt(chunk, end-n); err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		end -= n
+
+		trimmed := bytes.TrimRight(tail, " \t\r\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return bytes.TrimSpace(trimmed[i+1:]), nil
+		}
+	}
+
+	if tail = bytes.TrimSpace(tail); len(tail) == 0 {
+		return nil, nil
+	}
+
+	return tail, nil
+}
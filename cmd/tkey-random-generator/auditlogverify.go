@@ -0,0 +1,246 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"tkey-random-generator/randverify"
+)
+
+// runLog is the subcommand for the audit log. It returns the exit
+// code.
+func runLog(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return runLogVerify(args[1:])
+	}
+
+	le.Printf(`Usage: %[1]s log verify [flags..] FILE
+
+  Commands for the audit log written by generate and serve with
+  --audit-log. See "log verify --help".
+`, os.Args[0])
+
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		return 0
+	}
+
+	return 2
+}
+
+// runLogVerify is the subcommand checking an audit log. It returns the
+// exit code.
+func runLogVerify(args []string) int {
+	var filePubkeys []string
+	var helpOnly bool
+
+	cmdVerify := pflag.NewFlagSet("log verify", pflag.ExitOnError)
+	cmdVerify.SortFlags = false
+	cmdVerify.StringArrayVar(&filePubkeys, "pubkey", nil,
+		"Read a public key the entries are expected to be signed with, in hex, from `PUBKEY-FILE`. Pass several times for several TKeys.")
+	cmdVerify.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdVerify.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s log verify [flags..] FILE
+
+  Verifies the audit log in FILE. Does not need a connected TKey.
+
+  For every entry the signature of the hash is checked against the
+  public key in the entry, and the entry hash against the contents of
+  the entry. With --pubkey, entries signed with any other key are
+  reported. The chain is checked to start at entry 0, to have no gaps,
+  no entries out of order or repeated, and that every entry refers to
+  the one before it, which would otherwise mean that entries have been
+  removed, changed or inserted.
+
+  The log doesn't hold the random data. To show that some data is in
+  it, compute its hash with the nonce and context of the entry.
+  Entries removed from the end of the log can't be detected, unless
+  the entry hash of the last entry is kept elsewhere.
+
+  All problems found are listed. The return value is 0 if there are
+  none, otherwise non-zero.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdVerify.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdVerify.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdVerify.Usage()
+		return 0
+	}
+
+	if cmdVerify.NArg() < 1 {
+		le.Printf("FILE with the audit log required.\n\n")
+		cmdVerify.Usage()
+		return 2
+	} else if cmdVerify.NArg() > 1 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdVerify.Args()[1:], " "))
+		cmdVerify.Usage()
+		return 2
+	}
+
+	var pubkeys [][]byte
+	for _, filePubkey := range filePubkeys {
+		pubkey, err := readPubkey(filePubkey)
+		if err != nil {
+			le.Printf("Error reading public key: %v\n", err)
+			return 1
+		}
+		pubkeys = append(pubkeys, pubkey)
+	}
+
+	v, err := verifyAuditLog(cmdVerify.Args()[0], pubkeys)
+	if err != nil {
+		le.Printf("Error verifying: %v\n", err)
+		return 1
+	}
+
+	for _, problem := range v.problems {
+		le.Printf("%s\n", problem)
+	}
+
+	if len(v.problems) > 0 {
+		le.Printf("Audit log FAILED verification: %d problems in %d entries.\n", len(v.problems), v.entries)
+		return 1
+	}
+
+	le.Printf("Audit log of %d entries verified, covering %d bytes signed by:\n", v.entries, v.bytes)
+	for _, pubkey := range v.signers {
+		le.Printf("  %x\n", pubkey)
+	}
+	le.Printf("Last entry hash: %x\n", v.last.EntryHash)
+
+	return 0
+}
+
+// auditVerifier walks the entries of an audit log and collects the
+// problems found.
+type auditVerifier struct {
+	// pubkeys are the trusted public keys, or empty to trust all
+	pubkeys  [][]byte
+	entries  int
+	bytes    int64
+	signers  [][]byte
+	last     *auditEntry
+	problems []string
+}
+
+// verifyAuditLog verifies the audit log in path, with entries signed
+// with one of pubkeys, if any.
+func verifyAuditLog(path string, pubkeys [][]byte) (*auditVerifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	v := auditVerifier{pubkeys: pubkeys}
+
+	err = eachLine(f, func(lineNo int, line []byte) {
+		v.entries++
+		v.check(lineNo, line)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	if v.entries == 0 {
+		return nil, fmt.Errorf("no entries in %s", path)
+	}
+
+	return &v, nil
+}
+
+func (v *auditVerifier) problem(lineNo int, format string, a ...any) {
+	v.problems = append(v.problems, fmt.Sprintf("line %d: ", lineNo)+fmt.Sprintf(format, a...))
+}
+
+// check verifies the entry on line lineNo on its own and against the
+// entry before it.
+func (v *auditVerifier) check(lineNo int, line []byte) {
+	e, err := decodeAuditEntry(line)
+	if err != nil {
+		v.problem(lineNo, "%v", err)
+		return
+	}
+
+	if !ed25519.Verify(e.Pubkey, e.Hash, e.Signature) {
+		v.problem(lineNo, "entry %d: signature not valid", e.Seq)
+	}
+
+	if len(v.pubkeys) > 0 && !containsKey(v.pubkeys, e.Pubkey) {
+		v.problem(lineNo, "entry %d: signed with untrusted public key %x", e.Seq, e.Pubkey)
+	}
+
+	if (e.Scheme != randverify.SchemeLegacy || e.Context != "") && (e.Scheme != randverify.SchemeContext || e.Context == "") {
+		v.problem(lineNo, "entry %d: scheme %d doesn't match the context", e.Seq, e.Scheme)
+	}
+
+	if !bytes.Equal(hashAuditEntry(e), e.EntryHash) {
+		v.problem(lineNo, "entry %d: entry hash doesn't match its contents", e.Seq)
+	}
+
+	v.bytes += e.Bytes
+	if !containsKey(v.signers, e.Pubkey) {
+		v.signers = append(v.signers, e.Pubkey)
+	}
+
+	v.checkChain(lineNo, e)
+}
+
+// checkChain checks that e follows the entry before it.
+func (v *auditVerifier) checkChain(lineNo int, e *auditEntry) {
+	last := v.last
+	v.last = e
+
+	if last == nil {
+		if e.Seq != 0 || !bytes.Equal(e.Prev, genesisPrev) {
+			v.problem(lineNo, "log starts with entry %d, not entry 0", e.Seq)
+		}
+		return
+	}
+
+	switch {
+	case e.Seq == last.Seq && bytes.Equal(e.EntryHash, last.EntryHash):
+		v.problem(lineNo, "entry %d: repeated", e.Seq)
+	case e.Seq == last.Seq:
+		v.problem(lineNo, "entry %d: fork, differs from entry %d on the line before", e.Seq, last.Seq)
+	case e.Seq < last.Seq:
+		v.problem(lineNo, "entry %d: out of order, after entry %d", e.Seq, last.Seq)
+	case e.Seq == last.Seq+2:
+		v.problem(lineNo, "entry %d: gap, entry %d missing", e.Seq, e.Seq-1)
+	case e.Seq > last.Seq+2:
+		v.problem(lineNo, "entry %d: gap, entries %d to %d missing", e.Seq, last.Seq+1, e.Seq-1)
+	case !bytes.Equal(e.Prev, last.EntryHash):
+		v.problem(lineNo, "entry %d: doesn't refer to entry %d, changed or forked", e.Seq, last.Seq)
+	}
+
+	if e.Time.Before(last.Time) {
+		v.problem(lineNo, "entry %d: timestamp %s before the one of entry %d",
+			e.Seq, e.Time.Format(time.RFC3339), last.Seq)
+	}
+}
+
+// containsKey returns true if pubkey is one of pubkeys.
+func containsKey(pubkeys [][]byte, pubkey []byte) bool {
+	for _, k := range pubkeys {
+		if bytes.Equal(k, pubkey) {
+			return true
+		}
+	}
+
+	return false
+}
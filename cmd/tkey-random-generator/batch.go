@@ -0,0 +1,508 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"tkey-random-generator/randverify"
+)
+
+// The status of an item in a batch.
+const (
+	batchVerified   = "verified"
+	batchProvenance = "provenance-only"
+	batchUntrusted  = "untrusted"
+	batchFailed     = "failed"
+	batchError      = "error"
+)
+
+// batchManifest lists what verify --manifest verifies.
+type batchManifest struct {
+	Items []batchItem `json:"items"`
+}
+
+// batchItem is one item of a batch: either a bundle written by
+// generate --json, or random data with its signature. Paths are
+// relative to the manifest. Signature and Pubkey can also be given
+// inline, like the arguments of verify. Without Pubkey, the signature
+// is verified with each trusted public key.
+type batchItem struct {
+	Bundle    string `json:"bundle,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Binary    bool   `json:"binary,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Pubkey    string `json:"pubkey,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Context   string `json:"context,omitempty"`
+
+	// name is what the item is called in the report
+	name string
+}
+
+// batchResult is the outcome of verifying one item.
+type batchResult struct {
+	Item   string `json:"item"`
+	Status string `json:"status"`
+	Bytes  int64  `json:"bytes"`
+	// Pubkeys are the public keys the item is signed with, more
+	// than one for a bundle of --multi.
+	Pubkeys  []string `json:"pubkeys,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+
+	// untrusted is the number of public keys not trusted
+	untrusted int
+	// provenanceOnly is true if only the signatures of the hashes
+	// could be verified, not the output
+	provenanceOnly bool
+}
+
+// batchReport is the JSON report of verify --manifest.
+type batchReport struct {
+	Manifest   string        `json:"manifest"`
+	Time       string        `json:"time"`
+	Trusted    []string      `json:"trusted,omitempty"`
+	Items      int           `json:"items"`
+	Verified   int           `json:"verified"`
+	Provenance int           `json:"provenance_only"`
+	Untrusted  int           `json:"untrusted"`
+	Failed     int           `json:"failed"`
+	Errors     int           `json:"errors"`
+	Results    []batchResult `json:"results"`
+}
+
+// readBatch returns the items of the manifest in path, or found in it
+// if a directory, see scanBatch.
+func readBatch(path string) ([]batchItem, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	if info.IsDir() {
+		return scanBatch(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	var m batchManifest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s: %w", path, err)
+	}
+
+	if len(m.Items) == 0 {
+		return nil, fmt.Errorf("manifest %s has no items", path)
+	}
+
+	dir := filepath.Dir(path)
+	for i := range m.Items {
+		item := &m.Items[i]
+
+		switch {
+		case (item.Bundle == "") == (item.Data == ""):
+			return nil, fmt.Errorf("manifest %s: item %d needs either \"bundle\" or \"data\"", path, i)
+		case item.Data != "" && item.Signature == "":
+			return nil, fmt.Errorf("manifest %s: item %d needs \"signature\" with \"data\"", path, i)
+		case item.Bundle != "" && (item.Signature != "" || item.Pubkey != "" || item.Nonce != "" || item.Context != "" || item.Binary):
+			return nil, fmt.Errorf("manifest %s: item %d has a bundle, which holds the rest", path, i)
+		}
+
+		item.name = item.Bundle + item.Data
+		item.Bundle = batchPath(dir, item.Bundle)
+		item.Data = batchPath(dir, item.Data)
+		item.Signature = batchPath(dir, item.Signature)
+		item.Pubkey = batchPath(dir, item.Pubkey)
+	}
+
+	return m.Items, nil
+}
+
+// batchPath returns path relative to dir, unless absolute, empty or
+// given inline.
+func batchPath(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) || isInline(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// scanBatch returns the items found in dir and below: every bundle
+// written by generate --json, and every FILE.sig, with the signature of
+// FILE. FILE is expected to be binary, unless it ends in .hex or .txt.
+// The public key is the one in FILE.pub, if there.
+func scanBatch(dir string) ([]batchItem, error) {
+	var items []batchItem
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch filepath.Ext(path) {
+		case ".json":
+			if isBundle(path) {
+				items = append(items, batchItem{Bundle: path, name: name})
+			}
+		case ".sig":
+			data := strings.TrimSuffix(path, ".sig")
+			item := batchItem{
+				Data:      data,
+				Binary:    filepath.Ext(data) != ".hex" && filepath.Ext(data) != ".txt",
+				Signature: path,
+				name:      strings.TrimSuffix(name, ".sig"),
+			}
+			if _, err := os.Stat(data + ".pub"); err == nil {
+				item.Pubkey = data + ".pub"
+			}
+			items = append(items, item)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not scan %s: %w", dir, err)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no bundles or signatures found in %s", dir)
+	}
+
+	return items, nil
+}
+
+// isBundle returns true if path looks like a bundle of generate, not
+// another JSON file, like a segment manifest or a report.
+func isBundle(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var probe struct {
+		App      *bundleApp       `json:"app"`
+		Segments *json.RawMessage `json:"segments"`
+		Results  *json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return false
+	}
+
+	return probe.App != nil && probe.Segments == nil && probe.Results == nil
+}
+
+// batchCheck collects the outcome of verifying an item.
+type batchCheck struct {
+	result  batchResult
+	trusted [][]byte
+}
+
+func (c *batchCheck) problem(format string, a ...any) {
+	c.result.Problems = append(c.result.Problems, fmt.Sprintf(format, a...))
+}
+
+// signer records that the item is signed with pubkey.
+func (c *batchCheck) signer(pubkey []byte) {
+	c.result.Pubkeys = append(c.result.Pubkeys, hex.EncodeToString(pubkey))
+
+	if len(c.trusted) > 0 && !containsKey(c.trusted, pubkey) {
+		c.result.untrusted++
+		c.problem("signed with untrusted public key %x", pubkey)
+	}
+}
+
+// verify verifies item with nonce and context, if it doesn't have its
+// own, against the trusted public keys.
+func (item batchItem) verify(trusted [][]byte, nonce []byte, context string) batchResult {
+	c := batchCheck{result: batchResult{Item: item.name}, trusted: trusted}
+
+	var err error
+	if item.Bundle != "" {
+		err = c.bundle(item.Bundle)
+	} else {
+		err = c.item(item, nonce, context)
+	}
+
+	switch {
+	case err != nil:
+		c.result.Status = batchError
+		c.result.Problems = append(c.result.Problems, err.Error())
+	case len(c.result.Problems) == 0 && c.result.provenanceOnly:
+		c.result.Status = batchProvenance
+	case len(c.result.Problems) == 0:
+		c.result.Status = batchVerified
+	case c.result.untrusted == len(c.result.Problems):
+		// Only the keys are not trusted
+		c.result.Status = batchUntrusted
+	default:
+		c.result.Status = batchFailed
+	}
+
+	return c.result
+}
+
+// item verifies the random data and signature of item.
+func (c *batchCheck) item(item batchItem, nonce []byte, context string) error {
+	if item.Nonce != "" {
+		var err error
+		nonce, err = hex.DecodeString(item.Nonce)
+		if err != nil || len(nonce) < 1 || len(nonce) > NonceMaxBytes {
+			return fmt.Errorf("nonce needs to be 1 to %d bytes in hex", NonceMaxBytes)
+		}
+	}
+	if item.Context != "" {
+		context = item.Context
+	}
+
+	var message io.ReadCloser
+	var err error
+	if item.Binary {
+		message, err = openBinaryInput(item.Data)
+	} else {
+		var data []byte
+		data, err = readEncodedInput("random data", item.Data, 0)
+		message = io.NopCloser(bytes.NewReader(data))
+	}
+	if err != nil {
+		return err
+	}
+	defer message.Close()
+
+	signature, err := readEncodedInput("signature", item.Signature, ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+
+	var pubkey []byte
+	if item.Pubkey != "" {
+		pubkey, err = readPubkey(item.Pubkey)
+		if err != nil {
+			return err
+		}
+	} else if len(c.trusted) == 0 {
+		return fmt.Errorf("no public key, give one in the manifest or with --pubkey")
+	}
+
+	counter := &countingReader{r: message}
+	digest, err := randverify.HashReader(counter, nonce, context)
+	if err != nil {
+		return err
+	}
+	c.result.Bytes = counter.n
+
+	if pubkey == nil {
+		// Report the first key if none of them verify
+		pubkey = c.trusted[0]
+		for _, k := range c.trusted {
+			if ed25519.Verify(k, digest, signature) {
+				pubkey = k
+				break
+			}
+		}
+	}
+
+	c.signer(pubkey)
+
+	if !ed25519.Verify(pubkey, digest, signature) {
+		c.problem("%v", randverify.ErrSignature)
+	}
+
+	return nil
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// bundle verifies the signatures in the bundle in path, see
+// randverify.VerifyBundle.
+func (c *batchCheck) bundle(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	b, err := randverify.ReadBundle(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	res, err := randverify.VerifyBundle(b, randverify.Dir(filepath.Dir(path)))
+	if err != nil {
+		return err
+	}
+
+	c.result.Bytes = res.Bytes
+	for _, pubkey := range res.Pubkeys {
+		c.signer(pubkey)
+	}
+	c.result.Problems = append(c.result.Problems, res.Problems...)
+	c.result.provenanceOnly = res.ProvenanceOnly
+
+	return nil
+}
+
+// runVerifyBatch verifies the items of the manifest, or directory, in
+// path, jobs at a time, trusting the public keys in filePubkeys, or all
+// if none. nonce and context are used for items without their own. A
+// summary is printed and, if reportPath isn't empty, a JSON report
+// written to it. It returns the exit code.
+func runVerifyBatch(path string, filePubkeys []string, jobs int, reportPath string, nonce []byte, context string) int {
+	var trusted [][]byte
+	for _, filePubkey := range filePubkeys {
+		pubkey, err := readPubkey(filePubkey)
+		if err != nil {
+			le.Printf("Error reading public key: %v\n", err)
+			return 1
+		}
+		trusted = append(trusted, pubkey)
+	}
+
+	items, err := readBatch(path)
+	if err != nil {
+		le.Printf("Error reading batch: %v\n", err)
+		return 1
+	}
+
+	le.Printf("Verifying %d items, %d at a time ...\n", len(items), jobs)
+
+	results := make([]batchResult, len(items))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = items[i].verify(trusted, nonce, context)
+			}
+		}()
+	}
+	for i := range items {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	report := batchReport{
+		Manifest: path,
+		Time:     time.Now().UTC().Format(time.RFC3339),
+		Items:    len(results),
+		Results:  results,
+	}
+	for _, pubkey := range trusted {
+		report.Trusted = append(report.Trusted, hex.EncodeToString(pubkey))
+	}
+	for _, r := range results {
+		switch r.Status {
+		case batchVerified:
+			report.Verified++
+		case batchProvenance:
+			report.Provenance++
+		case batchUntrusted:
+			report.Untrusted++
+		case batchFailed:
+			report.Failed++
+		case batchError:
+			report.Errors++
+		}
+	}
+
+	// Keep stdout for the report
+	table := io.Writer(os.Stdout)
+	if reportPath == "-" {
+		table = os.Stderr
+	}
+	printBatch(table, results)
+
+	le.Printf("%d items: %d verified, %d provenance only, %d untrusted, %d failed, %d errors.\n",
+		report.Items, report.Verified, report.Provenance, report.Untrusted, report.Failed, report.Errors)
+	if report.Provenance > 0 {
+		le.Printf("Note: of bundles of --multi combine only that the TKeys signed their hashes is verified, not the output.\n")
+	}
+	if len(trusted) == 0 {
+		le.Printf("Note: no --pubkey given, so any public key is trusted.\n")
+	}
+
+	if reportPath != "" {
+		// The report of the last run is replaced
+		if err := writeJSONFile(reportPath, report, true); err != nil {
+			le.Printf("Error writing report: %v\n", err)
+			return 1
+		}
+	}
+
+	if report.Verified != report.Items {
+		return 1
+	}
+
+	return 0
+}
+
+// printBatch prints a table of results to out, and the problems found
+// on stderr.
+func printBatch(out io.Writer, results []batchResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "STATUS\tBYTES\tPUBKEY\tITEM\n")
+
+	for _, r := range results {
+		pubkey := "-"
+		switch len(r.Pubkeys) {
+		case 0:
+		case 1:
+			pubkey = r.Pubkeys[0][:16]
+		default:
+			pubkey = fmt.Sprintf("%d keys", len(r.Pubkeys))
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", r.Status, r.Bytes, pubkey, r.Item)
+	}
+	_ = w.Flush()
+
+	var problems []string
+	for _, r := range results {
+		for _, problem := range r.Problems {
+			problems = append(problems, fmt.Sprintf("%s: %s", r.Item, problem))
+		}
+	}
+	if len(problems) > 0 {
+		le.Printf("\n%s\n\n", strings.Join(problems, "\n"))
+	}
+}
@@ -0,0 +1,528 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/blake2s"
+)
+
+// pulseVersion is the version of the pulse format and of how the
+// pulse hash and nonce are computed.
+const pulseVersion = 1
+
+// pulseDomain separates pulse hashes from other BLAKE2s hashes.
+const pulseDomain = "tkey-random-generator beacon v1"
+
+// pulseNonceDomain separates the nonces of pulses from other BLAKE2s
+// hashes.
+const pulseNonceDomain = "tkey-random-generator beacon nonce v1"
+
+// genesisPrev is the prev of the first pulse in a chain.
+var genesisPrev = make([]byte, blake2s.Size)
+
+// pulse is one output of the beacon. Hash is the BLAKE2s hash of Data,
+// keyed with the nonce of the pulse, made and signed by the TKey. The
+// nonce binds the sequence number, time and previous pulse into the
+// signature, see pulseNonce. PulseHash is the BLAKE2s hash of all the
+// other fields, see hashPulse, and is what the next pulse refers to in
+// Prev.
+type pulse struct {
+	Version   int
+	Seq       uint64
+	Time      time.Time
+	Data      []byte
+	Hash      []byte
+	Signature []byte
+	Pubkey    []byte
+	Prev      []byte
+	PulseHash []byte
+}
+
+// jsonPulse is the default JSON representation of a pulse.
+type jsonPulse struct {
+	Version   int    `json:"version"`
+	Seq       uint64 `json:"seq"`
+	Time      string `json:"time"`
+	Data      string `json:"data"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+	Pubkey    string `json:"pubkey"`
+	Prev      string `json:"prev"`
+	PulseHash string `json:"pulse_hash"`
+}
+
+// nistPulse is a representation of a pulse modelled on the NIST
+// Randomness Beacon 2.0 format.
+type nistPulse struct {
+	Pulse nistPulseBody `json:"pulse"`
+}
+
+type nistPulseBody struct {
+	Version          string          `json:"version"`
+	CipherSuite      string          `json:"cipherSuite"`
+	Period           int64           `json:"period"`
+	CertificateID    string          `json:"certificateId"`
+	ChainIndex       int             `json:"chainIndex"`
+	PulseIndex       uint64          `json:"pulseIndex"`
+	TimeStamp        string          `json:"timeStamp"`
+	LocalRandomValue string          `json:"localRandomValue"`
+	HashValue        string          `json:"hashValue"`
+	ListValues       []nistListValue `json:"listValues"`
+	StatusCode       int             `json:"statusCode"`
+	SignatureValue   string          `json:"signatureValue"`
+	OutputValue      string          `json:"outputValue"`
+}
+
+type nistListValue struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// beaconOptions are the settings of the beacon subcommand.
+type beaconOptions struct {
+	path     string
+	bytes    int
+	interval time.Duration
+	count    int
+	format   string
+	verbose  bool
+	metrics  string
+}
+
+// runBeacon is the subcommand emitting hash-chained, signed pulses of
+// random data. It returns the exit code.
+func runBeacon(args []string) int {
+	if len(args) > 0 && args[0] == "verify" {
+		return runBeaconVerify(args[1:])
+	}
+
+	var dev deviceFlags
+	var opts beaconOptions
+	var helpOnly bool
+
+	cmdBeacon := pflag.NewFlagSet("beacon", pflag.ExitOnError)
+	cmdBeacon.SortFlags = false
+	dev.register(cmdBeacon)
+	cmdBeacon.IntVar(&opts.bytes, "bytes", 64,
+		fmt.Sprintf("Put `BYTES` of random data in every pulse, in [1,%d].", maxHTTPRequest))
+	cmdBeacon.DurationVar(&opts.interval, "interval", time.Minute,
+		"Emit a pulse every `DURATION`.")
+	cmdBeacon.IntVar(&opts.count, "count", 0,
+		"Stop after `N` pulses. Default is to run until interrupted.")
+	cmdBeacon.StringVar(&opts.format, "format", "json",
+		"Write pulses in `FORMAT`, json or nist.")
+	registerMetricsFlag(cmdBeacon, &opts.metrics)
+	cmdBeacon.BoolVarP(&opts.verbose, "verbose", "v", false, "Log every pulse.")
+	cmdBeacon.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdBeacon.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s beacon [flags..] FILE
+       %[1]s beacon verify --pubkey PUBKEY-FILE FILE
+
+  Runs a randomness beacon, appending a pulse to FILE every interval
+  as one line of JSON. A pulse holds fresh random data from the TKey,
+  the BLAKE2s hash of it and the TKey's signature of the hash, a
+  sequence number, a timestamp and the hash of the previous pulse,
+  chaining the pulses together. Uses one TKey only, since every pulse
+  is signed by a single key.
+
+  The TKey keys the hash with a nonce derived from the sequence
+  number, timestamp and previous pulse hash, so its signature covers
+  the place of the pulse in the chain. Needs device app version 3.
+
+  If FILE already has pulses the chain is verified and continued,
+  which needs the same TKey and USS as before.
+
+  Use "beacon verify" to check a chain. See "beacon verify --help".
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdBeacon.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdBeacon.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdBeacon.Usage()
+		return 0
+	}
+
+	if cmdBeacon.NArg() < 1 {
+		le.Printf("FILE to append pulses to required.\n\n")
+		cmdBeacon.Usage()
+		return 2
+	} else if cmdBeacon.NArg() > 1 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdBeacon.Args()[1:], " "))
+		cmdBeacon.Usage()
+		return 2
+	}
+	opts.path = cmdBeacon.Args()[0]
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdBeacon.Usage()
+		return 2
+	}
+
+	if err := opts.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdBeacon.Usage()
+		return 2
+	}
+
+	if err := beacon(dev, opts); err != nil {
+		le.Printf("Error running beacon: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func (o beaconOptions) validate() error {
+	if o.bytes < 1 || o.bytes > maxHTTPRequest {
+		return fmt.Errorf("--bytes needs to be in [1,%d]", maxHTTPRequest)
+	}
+
+	if o.interval < time.Second {
+		return fmt.Errorf("--interval needs to be at least 1s")
+	}
+
+	if o.count < 0 {
+		return fmt.Errorf("--count can't be negative")
+	}
+
+	if o.format != "json" && o.format != "nist" {
+		return fmt.Errorf("--format needs to be json or nist")
+	}
+
+	return nil
+}
+
+func beacon(dev deviceFlags, opts beaconOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	supervised, err := dev.supervise(ctx)
+	if err != nil {
+		return err
+	}
+
+	device, err := newSharedDevice(supervised)
+	if err != nil {
+		supervised.Close()
+		return err
+	}
+	defer device.Close()
+
+	// Every pulse is signed with a nonce, so fail now rather than at
+	// the first pulse
+	if version := device.App().Version; version < appVersionNonce {
+		return errAppTooOld("beacon", appVersionNonce, version)
+	}
+
+	last, err := readLastPulse(opts.path, device.Pubkey())
+	if err != nil {
+		return err
+	}
+
+	seq, prev := uint64(0), genesisPrev
+	if last != nil {
+		seq, prev = last.Seq+1, last.PulseHash
+		le.Printf("Continuing the chain in %s after pulse %d\n", opts.path, last.Seq)
+	}
+
+	f, err := os.OpenFile(opts.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", opts.path, err)
+	}
+	defer f.Close()
+
+	if err := serveMetrics(ctx, opts.metrics); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	le.Printf("Emitting a pulse every %v to %s\n", opts.interval, opts.path)
+	notifyReady(ctx, fmt.Sprintf("Emitting a pulse every %v", opts.interval))
+
+	for emitted := 0; opts.count == 0 || emitted < opts.count; emitted++ {
+		if emitted > 0 {
+			select {
+			case <-ctx.Done():
+				le.Printf("Stopped after %d pulses.\n", emitted)
+				return nil
+			case <-ticker.C:
+			}
+		}
+
+		p, err := newPulse(device, seq, prev, opts.bytes)
+		if err != nil {
+			if ctx.Err() != nil {
+				// Interrupted while waiting for the TKey
+				le.Printf("Stopped after %d pulses.\n", emitted)
+				return nil
+			}
+			return err
+		}
+
+		line, err := encodePulse(p, opts.format, opts.interval)
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("could not write pulse: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("could not write pulse: %w", err)
+		}
+
+		if opts.verbose {
+			le.Printf("Pulse %d: %x\n", p.Seq, p.PulseHash)
+		}
+
+		seq, prev = p.Seq+1, p.PulseHash
+	}
+
+	return nil
+}
+
+// newPulse makes pulse seq, following the pulse with hash prev, with n
+// bytes of signed random data from device.
+func newPulse(device *sharedDevice, seq uint64, prev []byte, n int) (*pulse, error) {
+	p := &pulse{
+		Version: pulseVersion,
+		Seq:     seq,
+		Time:    time.Now().UTC().Truncate(time.Second),
+		Pubkey:  device.Pubkey(),
+		Prev:    prev,
+	}
+
+	data, signature, hash, err := device.Signed(n, pulseNonce(p))
+	if err != nil {
+		return nil, err
+	}
+	p.Data, p.Hash, p.Signature = data, hash, signature
+	p.PulseHash = hashPulse(p)
+
+	return p, nil
+}
+
+// pulseNonce returns the nonce the TKey keys the hash of the data of p
+// with: the BLAKE2s hash of the string "tkey-random-generator beacon
+// nonce v1" followed by the sequence number and timestamp of p and the
+// previous pulse hash. Since the TKey signs it, a pulse can't be moved
+// to another place in the chain, or the chain rebuilt without it.
+func pulseNonce(p *pulse) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(pulseNonceDomain)
+	_ = binary.Write(&buf, binary.BigEndian, p.Seq)
+	_ = binary.Write(&buf, binary.BigEndian, p.Time.Unix())
+	buf.Write(p.Prev)
+
+	sum := blake2s.Sum256(buf.Bytes())
+
+	return sum[:]
+}
+
+// hashPulse returns the BLAKE2s hash of the version, sequence number,
+// timestamp, data hash, signature, public key and previous pulse hash
+// of p. The data is covered by its hash.
+func hashPulse(p *pulse) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(pulseDomain)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(p.Version))
+	_ = binary.Write(&buf, binary.BigEndian, p.Seq)
+	_ = binary.Write(&buf, binary.BigEndian, p.Time.Unix())
+	buf.Write(p.Hash)
+	buf.Write(p.Signature)
+	buf.Write(p.Pubkey)
+	buf.Write(p.Prev)
+
+	sum := blake2s.Sum256(buf.Bytes())
+
+	return sum[:]
+}
+
+// encodePulse returns p as a line of JSON in format.
+func encodePulse(p *pulse, format string, interval time.Duration) ([]byte, error) {
+	var v any
+
+	switch format {
+	case "nist":
+		v = nistPulse{Pulse: nistPulseBody{
+			Version:          strconv.Itoa(p.Version),
+			CipherSuite:      "BLAKE2s-256 Ed25519",
+			Period:           interval.Milliseconds(),
+			CertificateID:    hex.EncodeToString(p.Pubkey),
+			ChainIndex:       1,
+			PulseIndex:       p.Seq,
+			TimeStamp:        p.Time.Format(time.RFC3339),
+			LocalRandomValue: hex.EncodeToString(p.Data),
+			HashValue:        hex.EncodeToString(p.Hash),
+			ListValues: []nistListValue{
+				{Type: "previous", Value: hex.EncodeToString(p.Prev)},
+			},
+			SignatureValue: hex.EncodeToString(p.Signature),
+			OutputValue:    hex.EncodeToString(p.PulseHash),
+		}}
+	default:
+		v = jsonPulse{
+			Version:   p.Version,
+			Seq:       p.Seq,
+			Time:      p.Time.Format(time.RFC3339),
+			Data:      hex.EncodeToString(p.Data),
+			Hash:      hex.EncodeToString(p.Hash),
+			Signature: hex.EncodeToString(p.Signature),
+			Pubkey:    hex.EncodeToString(p.Pubkey),
+			Prev:      hex.EncodeToString(p.Prev),
+			PulseHash: hex.EncodeToString(p.PulseHash),
+		}
+	}
+
+	line, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode pulse: %w", err)
+	}
+
+	return line, nil
+}
+
+// decodePulse parses a line in either format.
+func decodePulse(line []byte) (*pulse, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(line, &probe); err != nil {
+		return nil, fmt.Errorf("not JSON: %w", err)
+	}
+
+	var fields [6]string
+	var p pulse
+	var timeStamp string
+
+	if _, ok := probe["pulse"]; ok {
+		var n nistPulse
+		if err := json.Unmarshal(line, &n); err != nil {
+			return nil, fmt.Errorf("bad pulse: %w", err)
+		}
+
+		version, err := strconv.Atoi(n.Pulse.Version)
+		if err != nil {
+			return nil, fmt.Errorf("bad version %q", n.Pulse.Version)
+		}
+
+		var prev string
+		for _, lv := range n.Pulse.ListValues {
+			if lv.Type == "previous" {
+				prev = lv.Value
+			}
+		}
+
+		p.Version, p.Seq, timeStamp = version, n.Pulse.PulseIndex, n.Pulse.TimeStamp
+		fields = [6]string{n.Pulse.LocalRandomValue, n.Pulse.HashValue, n.Pulse.SignatureValue,
+			n.Pulse.CertificateID, prev, n.Pulse.OutputValue}
+	} else {
+		var j jsonPulse
+		if err := json.Unmarshal(line, &j); err != nil {
+			return nil, fmt.Errorf("bad pulse: %w", err)
+		}
+
+		p.Version, p.Seq, timeStamp = j.Version, j.Seq, j.Time
+		fields = [6]string{j.Data, j.Hash, j.Signature, j.Pubkey, j.Prev, j.PulseHash}
+	}
+
+	if p.Version != pulseVersion {
+		return nil, fmt.Errorf("unsupported version %d", p.Version)
+	}
+
+	t, err := time.Parse(time.RFC3339, timeStamp)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp: %w", err)
+	}
+	p.Time = t
+
+	dst := []*[]byte{&p.Data, &p.Hash, &p.Signature, &p.Pubkey, &p.Prev, &p.PulseHash}
+	sizes := []int{-1, blake2s.Size, 64, 32, blake2s.Size, blake2s.Size}
+	names := []string{"data", "hash", "signature", "public key", "previous pulse hash", "pulse hash"}
+
+	for i := range dst {
+		b, err := hex.DecodeString(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %w", names[i], err)
+		}
+		if sizes[i] > 0 && len(b) != sizes[i] {
+			return nil, fmt.Errorf("%s is %d bytes, expected %d", names[i], len(b), sizes[i])
+		}
+		*dst[i] = b
+	}
+
+	return &p, nil
+}
+
+// readLastPulse verifies the chain of pulses in path against pubkey,
+// like beacon verify, and returns the last pulse, or nil if path
+// doesn't exist or is empty. A chain failing verification isn't
+// continued.
+func readLastPulse(path string, pubkey []byte) (*pulse, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	v := newChainVerifier(pubkey)
+	if err := v.read(f); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	if len(v.problems) > 0 {
+		return nil, fmt.Errorf("%s FAILED verification, not continuing it: %s (%d problems, see beacon verify)",
+			path, v.problems[0], len(v.problems))
+	}
+
+	return v.last, nil
+}
+
+// eachLine calls fn with the number and contents of every non-empty
+// line in r.
+func eachLine(r io.Reader, fn func(int, []byte)) error {
+	scanner := bufio.NewScanner(r)
+	// Pulses with lots of data are long lines
+	scanner.Buffer(make([]byte, 64*1024), 4*maxHTTPRequest)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		fn(lineNo, line)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,80 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChain writes pulses as lines of JSON to a file and returns its
+// path.
+func writeChain(t *testing.T, pulses []*pulse) string {
+	t.Helper()
+
+	var lines []string
+	for _, p := range pulses {
+		line, err := encodePulse(p, "json", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+
+	path := filepath.Join(t.TempDir(), "pulses")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestBeaconChain(t *testing.T) {
+	t.Parallel()
+
+	device, err := newSharedDevice(newFakeTKey(t, rand.Reader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pulses []*pulse
+	prev := genesisPrev
+	for seq := uint64(0); seq < 4; seq++ {
+		p, err := newPulse(device, seq, prev, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pulses = append(pulses, p)
+		prev = p.PulseHash
+	}
+
+	n, problems, err := verifyBeacon(writeChain(t, pulses), device.Pubkey())
+	if err != nil || n != 4 || len(problems) > 0 {
+		t.Fatalf("chain not verified: %d pulses, %v, %v", n, problems, err)
+	}
+
+	last, err := readLastPulse(writeChain(t, pulses), device.Pubkey())
+	if err != nil || last.Seq != 3 {
+		t.Fatalf("last pulse: %v, %v", last, err)
+	}
+
+	// Drop pulse 2 and chain pulse 3 to pulse 1 in its place. The
+	// pulse hashes are made by the host, but not the signature.
+	moved := *pulses[3]
+	moved.Seq, moved.Prev = 2, pulses[1].PulseHash
+	moved.PulseHash = hashPulse(&moved)
+
+	rechained := []*pulse{pulses[0], pulses[1], &moved}
+	_, problems, err = verifyBeacon(writeChain(t, rechained), device.Pubkey())
+	if err != nil || len(problems) != 1 || !strings.Contains(problems[0], "pulse 2: data doesn't match") {
+		t.Errorf("rechained chain: %v, %v", problems, err)
+	}
+
+	if _, err := readLastPulse(writeChain(t, rechained), device.Pubkey()); err == nil {
+		t.Errorf("continuing a rechained chain")
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"tkey-random-generator/randverify"
+)
+
+// runBeaconVerify is the subcommand checking a chain of beacon pulses.
+// It returns the exit code.
+func runBeaconVerify(args []string) int {
+	var filePubkey string
+	var helpOnly bool
+
+	cmdVerify := pflag.NewFlagSet("beacon verify", pflag.ExitOnError)
+	cmdVerify.SortFlags = false
+	cmdVerify.StringVar(&filePubkey, "pubkey", "",
+		"Read the public key the pulses are expected to be signed with, in hex, from `PUBKEY-FILE`.")
+	cmdVerify.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdVerify.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s beacon verify --pubkey PUBKEY-FILE FILE
+
+  Verifies a chain of beacon pulses in FILE, in either format. Does not
+  need a connected TKey.
+
+  For every pulse the data is checked against its hash, keyed with the
+  nonce of the pulse, the signature of the hash against the public key
+  in PUBKEY-FILE, and the pulse hash against the contents of the
+  pulse. The chain is checked to start
+  at pulse 0, to have no gaps, no pulses out of order or repeated, and
+  that every pulse refers to the one before it, which would otherwise
+  mean that the chain has been forked.
+
+  All problems found are listed. The return value is 0 if there are
+  none, otherwise non-zero.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdVerify.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdVerify.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdVerify.Usage()
+		return 0
+	}
+
+	if filePubkey == "" {
+		le.Printf("--pubkey is required.\n\n")
+		cmdVerify.Usage()
+		return 2
+	}
+
+	if cmdVerify.NArg() < 1 {
+		le.Printf("FILE with pulses required.\n\n")
+		cmdVerify.Usage()
+		return 2
+	} else if cmdVerify.NArg() > 1 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdVerify.Args()[1:], " "))
+		cmdVerify.Usage()
+		return 2
+	}
+
+	pubkey, err := readPubkey(filePubkey)
+	if err != nil {
+		le.Printf("Error reading public key: %v\n", err)
+		return 1
+	}
+
+	pulses, problems, err := verifyBeacon(cmdVerify.Args()[0], pubkey)
+	if err != nil {
+		le.Printf("Error verifying: %v\n", err)
+		return 1
+	}
+
+	for _, problem := range problems {
+		le.Printf("%s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		le.Printf("Chain FAILED verification: %d problems in %d pulses.\n", len(problems), pulses)
+		return 1
+	}
+
+	le.Printf("Chain of %d pulses verified.\n", pulses)
+
+	return 0
+}
+
+// chainVerifier walks a chain of pulses and collects the problems
+// found.
+type chainVerifier struct {
+	pubkey   []byte
+	pulses   int
+	last     *pulse
+	seen     map[uint64]seenPulse
+	problems []string
+}
+
+func newChainVerifier(pubkey []byte) *chainVerifier {
+	return &chainVerifier{
+		pubkey: pubkey,
+		seen:   map[uint64]seenPulse{},
+	}
+}
+
+// read checks every pulse read from r.
+func (v *chainVerifier) read(r io.Reader) error {
+	return eachLine(r, func(lineNo int, line []byte) {
+		v.pulses++
+		v.check(lineNo, line)
+	})
+}
+
+type seenPulse struct {
+	line      int
+	pulseHash []byte
+}
+
+// verifyBeacon verifies the chain of pulses in path against pubkey. It
+// returns the number of pulses and a description of every problem
+// found.
+func verifyBeacon(path string, pubkey []byte) (int, []string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	v := newChainVerifier(pubkey)
+	if err := v.read(f); err != nil {
+		return 0, nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	if v.pulses == 0 {
+		return 0, nil, fmt.Errorf("no pulses in %s", path)
+	}
+
+	return v.pulses, v.problems, nil
+}
+
+func (v *chainVerifier) problem(lineNo int, format string, a ...any) {
+	v.problems = append(v.problems, fmt.Sprintf("line %d: ", lineNo)+fmt.Sprintf(format, a...))
+}
+
+// check verifies the pulse on line lineNo on its own and against the
+// pulses before it.
+func (v *chainVerifier) check(lineNo int, line []byte) {
+	p, err := decodePulse(line)
+	if err != nil {
+		v.problem(lineNo, "%v", err)
+		return
+	}
+
+	if err := randverify.VerifyHash(p.Hash, bytes.NewReader(p.Data), pulseNonce(p), ""); err != nil {
+		v.problem(lineNo, "pulse %d: data doesn't match its hash with the nonce of the pulse", p.Seq)
+	}
+
+	if !bytes.Equal(p.Pubkey, v.pubkey) {
+		v.problem(lineNo, "pulse %d: signed with public key %x", p.Seq, p.Pubkey)
+	}
+
+	if !ed25519.Verify(v.pubkey, p.Hash, p.Signature) {
+		v.problem(lineNo, "pulse %d: signature not valid", p.Seq)
+	}
+
+	if !bytes.Equal(hashPulse(p), p.PulseHash) {
+		v.problem(lineNo, "pulse %d: pulse hash doesn't match its contents", p.Seq)
+	}
+
+	v.checkChain(lineNo, p)
+}
+
+// checkChain checks that p continues the chain.
+func (v *chainVerifier) checkChain(lineNo int, p *pulse) {
+	defer func() {
+		if _, ok := v.seen[p.Seq]; !ok {
+			v.seen[p.Seq] = seenPulse{line: lineNo, pulseHash: p.PulseHash}
+		}
+		if v.last == nil || p.Seq > v.last.Seq {
+			v.last = p
+		}
+	}()
+
+	if seen, ok := v.seen[p.Seq]; ok {
+		if bytes.Equal(seen.pulseHash, p.PulseHash) {
+			v.problem(lineNo, "pulse %d: repeats line %d", p.Seq, seen.line)
+		} else {
+			v.problem(lineNo, "pulse %d: fork, differs from pulse %d on line %d", p.Seq, p.Seq, seen.line)
+		}
+		return
+	}
+
+	if v.last == nil {
+		if p.Seq != 0 || !bytes.Equal(p.Prev, genesisPrev) {
+			v.problem(lineNo, "chain starts with pulse %d, not pulse 0", p.Seq)
+		}
+		return
+	}
+
+	switch {
+	case p.Seq < v.last.Seq:
+		v.problem(lineNo, "pulse %d: out of order, after pulse %d", p.Seq, v.last.Seq)
+		return
+	case p.Seq == v.last.Seq+2:
+		v.problem(lineNo, "pulse %d: gap, pulse %d missing", p.Seq, p.Seq-1)
+	case p.Seq > v.last.Seq+2:
+		v.problem(lineNo, "pulse %d: gap, pulses %d to %d missing", p.Seq, v.last.Seq+1, p.Seq-1)
+	case !bytes.Equal(p.Prev, v.last.PulseHash):
+		v.problem(lineNo, "pulse %d: fork, doesn't refer to pulse %d", p.Seq, v.last.Seq)
+	}
+
+	if p.Time.Before(v.last.Time) {
+		v.problem(lineNo, "pulse %d: timestamp %s before the one of pulse %d",
+			p.Seq, p.Time.Format(time.RFC3339), v.last.Seq)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tillitis/tkeyclient"
+	"tkey-random-generator/randverify"
+)
+
+// The formats of the bundle, see randverify.Bundle.
+type (
+	bundle       = randverify.Bundle
+	bundleApp    = randverify.App
+	reseedPolicy = randverify.ReseedPolicy
+	mixInfo      = randverify.MixInfo
+	multiInfo    = randverify.MultiInfo
+	deviceInfo   = randverify.DeviceInfo
+)
+
+// appOf returns the name and version of the device app in nameVer,
+// and the digest of its binary, if known.
+func appOf(nameVer *tkeyclient.NameVersion, digest string) bundleApp {
+	return bundleApp{Name: nameVer.Name0 + nameVer.Name1, Version: nameVer.Version, SHA512: digest}
+}
+
+// writeBundle writes b as JSON to path, or stdout if path is "-".
+func writeBundle(path string, b bundle, force bool) error {
+	return writeJSONFile(path, b, force)
+}
+
+// writeJSONFile writes v as indented JSON to path, like writeOutput,
+// or stdout if path is "-".
+func writeJSONFile(path string, v any, force bool) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("json.MarshalIndent: %w", err)
+	}
+	out = append(out, '\n')
+
+	if path == "-" {
+		if _, err := os.Stdout.Write(out); err != nil {
+			return fmt.Errorf("could not write JSON: %w", err)
+		}
+		return nil
+	}
+
+	return writeOutput(path, out, force)
+}
@@ -0,0 +1,362 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	"github.com/tillitis/tkeyclient"
+	"github.com/tillitis/tkeyutil"
+)
+
+// deviceFlags are the flags used by every subcommand talking to a
+// TKey.
+type deviceFlags struct {
+	ports        []string
+	multi        string
+	speed        int
+	enterUSS     bool
+	fileUSS      string
+	credUSS      string
+	forceFullUSS bool
+	appFile      string
+	appSHA512    string
+
+	// canMulti is true if the subcommand can use several TKeys
+	canMulti bool
+	// secret is the USS, once read
+	secret []byte
+	// loadedApp is the device app in appFile, once read and checked
+	loadedApp []byte
+}
+
+// register adds the device flags to fs.
+func (f *deviceFlags) register(fs *pflag.FlagSet) {
+	fs.StringArrayVarP(&f.ports, "port", "p", nil,
+		"Set serial port device `PATH`. If this is not passed, auto-detection will be attempted.")
+	fs.IntVar(&f.speed, "speed", tkeyclient.SerialSpeed,
+		"Set serial port speed in `BPS` (bits per second).")
+	fs.BoolVar(&f.enterUSS, "uss", false,
+		"Enable typing of a phrase to be hashed as the User Supplied Secret. The USS is loaded onto the TKey along with the app itself. A different USS results in different Compound Device Identifier, different start of the random sequence, and another key pair used for signing.")
+	fs.StringVar(&f.fileUSS, "uss-file", "",
+		"Read `FILE` and hash its contents as the USS. Use '-' (dash) to read from stdin. The full contents are hashed unmodified (e.g. newlines are not stripped).")
+	fs.StringVar(&f.credUSS, "uss-credential", "",
+		"Read the USS from the systemd credential `NAME`, in $CREDENTIALS_DIRECTORY. Like --uss-file, for services.")
+	fs.BoolVar(&f.forceFullUSS, "force-full-uss", false,
+		"Use 32 byte USS digest. Default is 31.")
+	fs.StringVar(&f.appFile, "app", "",
+		"Load the device app in `FILE` instead of the embedded one. Needs --app-sha512.")
+	fs.StringVar(&f.appSHA512, "app-sha512", "",
+		"Refuse to load the device app of --app unless its SHA-512 digest is `DIGEST`, in hex.")
+}
+
+// registerMulti adds the flag for using several TKeys at once to fs.
+func (f *deviceFlags) registerMulti(fs *pflag.FlagSet) {
+	fs.StringVar(&f.multi, "multi", "",
+		"Use all TKeys given by passing --port several times, or all detected if none, in `MODE`: combine, XORing their output so no single TKey controls it, or stripe, reading from all in parallel for speed. Several --port imply combine.")
+	f.canMulti = true
+}
+
+// validate checks that the device flags are used together in a
+// sensible way.
+func (f *deviceFlags) validate() error {
+	if len(f.ports) > 1 && !f.canMulti {
+		return fmt.Errorf("this command uses only one TKey, pass --port once")
+	}
+
+	if len(f.ports) > 1 && f.multi == "" {
+		f.multi = multiCombine
+	}
+
+	if f.multi != "" && f.multi != multiCombine && f.multi != multiStripe {
+		return fmt.Errorf("--multi needs to be %s or %s", multiCombine, multiStripe)
+	}
+
+	sources := 0
+	for _, given := range []bool{f.enterUSS, f.fileUSS != "", f.credUSS != ""} {
+		if given {
+			sources++
+		}
+	}
+
+	if sources > 1 {
+		return fmt.Errorf("pass only one of --uss, --uss-file or --uss-credential")
+	}
+
+	if f.forceFullUSS && sources == 0 {
+		return fmt.Errorf("--force-full-uss unusable unless you also specify --uss, --uss-file or --uss-credential")
+	}
+
+	if f.appFile != "" && f.appSHA512 == "" {
+		return fmt.Errorf("--app needs --app-sha512, the digest of the app to load")
+	}
+
+	if f.appSHA512 != "" {
+		if f.appFile == "" {
+			return fmt.Errorf("--app-sha512 unusable unless you also specify --app")
+		}
+		if digest, err := hex.DecodeString(f.appSHA512); err != nil || len(digest) != sha512.Size {
+			return fmt.Errorf("--app-sha512 needs to be a SHA-512 digest, %d bytes in hex", sha512.Size)
+		}
+	}
+
+	return nil
+}
+
+// connect connects to a TKey, loads the device app in app if needed and
+// checks that it's the app we expect. uss, if not nil, returns the USS to load the app with.
+// If required is true, app has to be loaded, not already running.
+func connect(devPath string, speed int, app []byte, required bool, uss func() ([]byte, error), forceFullUSS bool) (RandomGen, error) {
+	tkeyclient.SilenceLogging()
+
+	if devPath == "" {
+		var err error
+		devPath, err = tkeyclient.DetectSerialPort(true)
+		if err != nil {
+			return RandomGen{}, fmt.Errorf("DetectSerialPort: %w", err)
+		}
+	}
+
+	tk := tkeyclient.New()
+	le.Printf("Connecting to device on serial port %s...\n", devPath)
+
+	options := []func(*tkeyclient.TillitisKey){}
+
+	if speed != 0 {
+		options = append(options, tkeyclient.WithSpeed(speed))
+	}
+
+	if forceFullUSS {
+		options = append(options, tkeyclient.WithFullUss())
+	}
+
+	if err := tk.Connect(devPath, options...); err != nil {
+		return RandomGen{}, fmt.Errorf("could not open %s: %w", devPath, err)
+	}
+
+	randomGen := New(tk)
+
+	firmware, err := loadApp(tk, app, required, uss)
+	if err != nil {
+		randomGen.Close()
+		return RandomGen{}, fmt.Errorf("couldn't load app: %w", err)
+	}
+	randomGen.firmware = firmware
+	if firmware != nil {
+		randomGen.appDigest = appDigest(app)
+	}
+
+	if !isWantedApp(randomGen) {
+		randomGen.Close()
+		return RandomGen{}, fmt.Errorf("the TKey may already be running an app, but not the expected. Please unplug and plug it in again")
+	}
+
+	if err := checkSelfTest(randomGen); err != nil {
+		randomGen.Close()
+		return RandomGen{}, err
+	}
+
+	return randomGen, nil
+}
+
+// checkSelfTest returns an error if the device app reports that its
+// known-answer tests failed. Device apps too old to run self-tests
+// are accepted.
+func checkSelfTest(randomGen RandomGen) error {
+	nameVer, err := randomGen.GetAppNameVersion()
+	if err != nil {
+		return fmt.Errorf("GetAppNameVersion failed: %w", err)
+	}
+
+	if nameVer.Version < appVersionExtended {
+		return nil
+	}
+
+	result, err := randomGen.SelfTest()
+	if err != nil {
+		return fmt.Errorf("SelfTest failed: %w", err)
+	}
+
+	if !result.Passed() {
+		return fmt.Errorf("device app self-test %s. Refusing to use it", result)
+	}
+
+	return nil
+}
+
+// connect connects to the TKey described by the flags.
+func (f *deviceFlags) connect() (RandomGen, error) {
+	// What's already running may not be the app of --app
+	return f.connectApp(f.appFile != "")
+}
+
+// connectApp connects to the TKey described by the flags. If required
+// is true, the app has to be loaded, not already running.
+func (f *deviceFlags) connectApp(required bool) (RandomGen, error) {
+	var uss func() ([]byte, error)
+	if f.hasUSS() {
+		uss = f.uss
+	}
+
+	app, err := f.app()
+	if err != nil {
+		return RandomGen{}, err
+	}
+
+	randomGen, err := connect(f.port(), f.speed, app, required, uss, f.forceFullUSS)
+	if err != nil {
+		return RandomGen{}, err
+	}
+	metrics.connected.Set(1)
+
+	return randomGen, nil
+}
+
+// port returns the serial port of the one TKey to use, or "" to
+// detect it.
+func (f *deviceFlags) port() string {
+	if len(f.ports) == 0 {
+		return ""
+	}
+
+	return f.ports[0]
+}
+
+// several returns true if several TKeys are to be used at once.
+func (f *deviceFlags) several() bool {
+	return f.multi != ""
+}
+
+// paths returns the serial ports of all TKeys to use at once, the ones
+// given or, if none, all detected.
+func (f *deviceFlags) paths() ([]string, error) {
+	paths := f.ports
+	if len(paths) == 0 {
+		ports, err := tkeyclient.GetSerialPorts()
+		if err != nil {
+			return nil, fmt.Errorf("GetSerialPorts: %w", err)
+		}
+
+		for _, port := range ports {
+			paths = append(paths, port.DevPath)
+		}
+	}
+
+	if len(paths) < 2 {
+		return nil, fmt.Errorf("--multi needs at least two TKeys, found %d", len(paths))
+	}
+
+	return paths, nil
+}
+
+// forPort returns the flags for the one TKey on path. The USS and the
+// app are shared, so they have to be read first.
+func (f *deviceFlags) forPort(path string) *deviceFlags {
+	g := *f
+	g.ports = []string{path}
+	g.multi = ""
+
+	return &g
+}
+
+// uss returns the USS, asking for it or reading it from file the
+// first time only, so that the app can be loaded again without asking.
+func (f *deviceFlags) uss() ([]byte, error) {
+	if f.secret != nil {
+		return f.secret, nil
+	}
+
+	var err error
+
+	if f.enterUSS {
+		f.secret, err = tkeyutil.InputUSS()
+		if err != nil {
+			return nil, fmt.Errorf("InputUSS: %w", err)
+		}
+	}
+	if f.fileUSS != "" {
+		f.secret, err = tkeyutil.ReadUSS(f.fileUSS)
+		if err != nil {
+			return nil, fmt.Errorf("ReadUSS: %w", err)
+		}
+	}
+	if f.credUSS != "" {
+		path, err := credentialPath(f.credUSS)
+		if err != nil {
+			return nil, err
+		}
+
+		f.secret, err = tkeyutil.ReadUSS(path)
+		if err != nil {
+			return nil, fmt.Errorf("ReadUSS: %w", err)
+		}
+	}
+
+	return f.secret, nil
+}
+
+// app returns the device app to load: the embedded one, or the one in
+// --app, read the first time only and refused unless its digest is
+// the one in --app-sha512.
+func (f *deviceFlags) app() ([]byte, error) {
+	if f.appFile == "" {
+		return appBinary, nil
+	}
+
+	if f.loadedApp != nil {
+		return f.loadedApp, nil
+	}
+
+	app, err := os.ReadFile(f.appFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read app: %w", err)
+	}
+
+	want, err := hex.DecodeString(f.appSHA512)
+	if err != nil {
+		return nil, fmt.Errorf("bad --app-sha512: %w", err)
+	}
+
+	digest := sha512.Sum512(app)
+	if !bytes.Equal(digest[:], want) {
+		return nil, fmt.Errorf("%s has SHA-512 digest %x, not the one in --app-sha512. Refusing to load it", f.appFile, digest)
+	}
+
+	le.Printf("Using device app %s, SHA-512 %x\n", f.appFile, digest)
+	f.loadedApp = app
+
+	return app, nil
+}
+
+// appDigest returns the SHA-512 digest of the device app binary app,
+// in hex.
+func appDigest(app []byte) string {
+	digest := sha512.Sum512(app)
+	return hex.EncodeToString(digest[:])
+}
+
+// hasUSS returns true if a USS is to be loaded with the app.
+func (f *deviceFlags) hasUSS() bool {
+	return f.enterUSS || f.fileUSS != "" || f.credUSS != ""
+}
+
+// tkeyReader reads random data from a TKey, running a device app of
+// appVersion, and re-inits the hash on the TKey when closed.
+type tkeyReader struct {
+	RandomGen
+	appVersion uint32
+}
+
+func (r tkeyReader) Close() error {
+	if err := endSession(r.RandomGen, r.appVersion); err != nil {
+		le.Printf("%v\n", err)
+	}
+
+	return r.RandomGen.Close()
+}
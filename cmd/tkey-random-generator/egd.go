@@ -0,0 +1,221 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"tkey-random-generator/randsock"
+)
+
+// Commands of the Entropy Gathering Daemon protocol.
+const (
+	egdEntropyLevel = 0x00
+	egdReadNonBlock = 0x01
+	egdReadBlock    = 0x02
+	egdWriteEntropy = 0x03
+	egdGetPID       = 0x04
+)
+
+// runEGD is the subcommand serving random data over a Unix domain
+// socket speaking the Entropy Gathering Daemon protocol. It returns
+// the exit code.
+func runEGD(args []string) int {
+	var dev deviceFlags
+	var opts serveOptions
+	var helpOnly bool
+
+	cmdEGD := pflag.NewFlagSet("egd", pflag.ExitOnError)
+	cmdEGD.SortFlags = false
+	dev.register(cmdEGD)
+	dev.registerMulti(cmdEGD)
+	cmdEGD.StringVar(&opts.socket, "socket", defaultEGDPath(),
+		"Listen on Unix domain socket `PATH`.")
+	cmdEGD.StringVar(&opts.socketMode, "socket-mode", "0660",
+		"Set the permissions of the socket to `MODE`, in octal.")
+	cmdEGD.IntVar(&opts.buffer, "buffer", 64*1024,
+		"Keep up to `BYTES` of random data prefetched from the TKey.")
+	cmdEGD.IntVar(&opts.lowWater, "low-water", 16*1024,
+		"Refill the prefetch buffer when it holds `BYTES` or less.")
+	cmdEGD.StringVar(&opts.auditLog, "audit-log", "",
+		"When stopping, have every TKey sign all it returned and append the signatures to the audit log `FILE`.")
+	registerMetricsFlag(cmdEGD, &opts.metrics)
+	cmdEGD.BoolVarP(&opts.verbose, "verbose", "v", false, "Log connections and requests.")
+	cmdEGD.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdEGD.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s egd [flags..]
+
+  Works like serve, but speaks the protocol of the Entropy Gathering
+  Daemon (EGD) on the Unix domain socket, so that programs that can
+  get entropy from EGD, like OpenSSL's RAND_egd(), can use the TKey.
+
+  All EGD commands are supported. Entropy written by clients is read
+  and thrown away, and the entropy level reported is the number of
+  bits prefetched from the TKey.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdEGD.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdEGD.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdEGD.Usage()
+		return 0
+	}
+
+	if cmdEGD.NArg() > 0 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdEGD.Args(), " "))
+		cmdEGD.Usage()
+		return 2
+	}
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdEGD.Usage()
+		return 2
+	}
+
+	mode, err := opts.validate()
+	if err != nil {
+		le.Printf("%v\n\n", err)
+		cmdEGD.Usage()
+		return 2
+	}
+
+	handle := func(conn net.Conn, sched *fairScheduler, pool *prefetchPool) {
+		handleEGDConn(conn, sched, pool, opts.verbose)
+	}
+
+	if err := serveSocket(dev, opts, mode, handle); err != nil {
+		le.Printf("Error serving: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// defaultEGDPath returns the default path of the EGD socket, next to
+// the serve daemon's socket.
+func defaultEGDPath() string {
+	return filepath.Join(filepath.Dir(randsock.DefaultPath()), "tkey-random-generator.egd")
+}
+
+// handleEGDConn serves EGD requests on conn until the client goes
+// away or sends an unknown command.
+func handleEGDConn(conn net.Conn, sched *fairScheduler, pool *prefetchPool, verbose bool) {
+	for {
+		err := serveEGDRequest(conn, sched, pool, verbose)
+		if err != nil {
+			if verbose && !errors.Is(err, io.EOF) {
+				le.Printf("EGD request failed: %v\n", err)
+			}
+			return
+		}
+	}
+}
+
+// serveEGDRequest reads one EGD request from rw and writes the
+// response.
+func serveEGDRequest(rw io.ReadWriter, sched *fairScheduler, pool *prefetchPool, verbose bool) error {
+	var cmd [1]byte
+	if _, err := io.ReadFull(rw, cmd[:]); err != nil {
+		return err
+	}
+
+	switch cmd[0] {
+	case egdEntropyLevel:
+		var rsp [4]byte
+		binary.BigEndian.PutUint32(rsp[:], uint32(min(pool.Available(), math.MaxUint32/8))*8)
+		_, err := rw.Write(rsp[:])
+
+		return err
+
+	case egdReadNonBlock:
+		n, err := readEGDCount(rw)
+		if err != nil {
+			return err
+		}
+
+		data, err := pool.TryTake(n)
+		if err != nil {
+			return err
+		}
+
+		if verbose {
+			le.Printf("Served %d of %d bytes without blocking\n", len(data), n)
+		}
+
+		_, err = rw.Write(append([]byte{byte(len(data))}, data...))
+
+		return err
+
+	case egdReadBlock:
+		n, err := readEGDCount(rw)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+
+		data, err := sched.Get(n)
+		if err != nil {
+			return err
+		}
+
+		if verbose {
+			le.Printf("Served %d bytes\n", n)
+		}
+
+		_, err = rw.Write(data)
+
+		return err
+
+	case egdWriteEntropy:
+		// Bits of entropy (2 bytes), length and the data. We
+		// only trust the TKey, so throw it away.
+		var hdr [3]byte
+		if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+			return err
+		}
+		_, err := io.CopyN(io.Discard, rw, int64(hdr[2]))
+
+		return err
+
+	case egdGetPID:
+		pid := strconv.Itoa(os.Getpid())
+		_, err := rw.Write(append([]byte{byte(len(pid))}, pid...))
+
+		return err
+
+	default:
+		return fmt.Errorf("unknown command %#02x", cmd[0])
+	}
+}
+
+// readEGDCount reads the one byte number of bytes asked for in a read
+// request.
+func readEGDCount(r io.Reader) (int, error) {
+	var n [1]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return 0, err
+	}
+
+	return int(n[0]), nil
+}
@@ -0,0 +1,118 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// egdConn is a connection from an EGD client that sent the bytes of
+// Reader, collecting the response in Buffer.
+type egdConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *egdConn) Read(p []byte) (int, error) {
+	return c.Reader.Read(p)
+}
+
+func TestServeEGDRequest(t *testing.T) {
+	t.Parallel()
+
+	pool := startPool(t, 4096, 1024, newFakeTKey(t, rand.Reader))
+	sched := newFairScheduler(pool, schedulerQuantum)
+	go sched.run()
+	defer sched.Close()
+
+	pid := strconv.Itoa(os.Getpid())
+
+	for _, tc := range []struct {
+		name  string
+		in    []byte
+		check func(out []byte) error
+	}{
+		{"entropy level", []byte{egdEntropyLevel}, func(out []byte) error {
+			if len(out) != 4 {
+				return fmt.Errorf("got %d bytes, want 4", len(out))
+			}
+			if bits := binary.BigEndian.Uint32(out); bits%8 != 0 || bits > 4096*8 {
+				return fmt.Errorf("entropy level %d", bits)
+			}
+			return nil
+		}},
+		{"read without blocking", []byte{egdReadNonBlock, 200}, func(out []byte) error {
+			if len(out) < 1 || int(out[0]) != len(out)-1 || out[0] > 200 {
+				return fmt.Errorf("got %d bytes, want up to 200 after their count", len(out))
+			}
+			return nil
+		}},
+		{"read", []byte{egdReadBlock, 255}, func(out []byte) error {
+			if len(out) != 255 {
+				return fmt.Errorf("got %d bytes, want 255", len(out))
+			}
+			return nil
+		}},
+		{"read nothing", []byte{egdReadBlock, 0}, func(out []byte) error {
+			if len(out) != 0 {
+				return fmt.Errorf("got %d bytes, want none", len(out))
+			}
+			return nil
+		}},
+		{"write entropy", []byte{egdWriteEntropy, 0, 24, 3, 'a', 'b', 'c'}, func(out []byte) error {
+			if len(out) != 0 {
+				return fmt.Errorf("got %d bytes, want none", len(out))
+			}
+			return nil
+		}},
+		{"get PID", []byte{egdGetPID}, func(out []byte) error {
+			if want := append([]byte{byte(len(pid))}, pid...); !bytes.Equal(out, want) {
+				return fmt.Errorf("got %q, want %q", out, want)
+			}
+			return nil
+		}},
+	} {
+		conn := &egdConn{Reader: bytes.NewReader(tc.in)}
+		if err := serveEGDRequest(conn, sched, pool, false); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+
+			continue
+		}
+		if err := tc.check(conn.Bytes()); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if n, _ := conn.Reader.Read(make([]byte, 1)); n != 0 {
+			t.Errorf("%s: request not read to the end", tc.name)
+		}
+	}
+}
+
+func TestServeEGDRequestBad(t *testing.T) {
+	t.Parallel()
+
+	pool := startPool(t, 4096, 1024, newFakeTKey(t, rand.Reader))
+	sched := newFairScheduler(pool, schedulerQuantum)
+	go sched.run()
+	defer sched.Close()
+
+	for name, in := range map[string][]byte{
+		"nothing":               nil,
+		"unknown command":       {0x05},
+		"read without count":    {egdReadBlock},
+		"truncated entropy":     {egdWriteEntropy, 0, 24, 3, 'a'},
+		"truncated entropy hdr": {egdWriteEntropy, 0},
+	} {
+		conn := &egdConn{Reader: bytes.NewReader(in)}
+		if err := serveEGDRequest(conn, sched, pool, false); err == nil {
+			t.Errorf("%s: got %q, want an error", name, conn.Bytes())
+		}
+	}
+}
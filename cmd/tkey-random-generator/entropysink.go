@@ -0,0 +1,23 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import "time"
+
+// entropySink is where feed-kernel puts the random data. On Linux
+// it's the kernel's input pool, but anything crediting entropy will
+// do, which also makes it possible to run the feeder without root.
+type entropySink interface {
+	// EntropyAvail returns the sink's estimate of available entropy
+	// in bits.
+	EntropyAvail() (int, error)
+	// Wait blocks until the sink asks for more entropy or timeout
+	// has passed. It returns true if the sink asked.
+	Wait(timeout time.Duration) (bool, error)
+	// AddEntropy mixes data into the sink, crediting it with bits
+	// of entropy.
+	AddEntropy(data []byte, bits int) error
+	// Close releases the sink.
+	Close() error
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const randomDevice = "/dev/random"
+
+// kernelSink credits entropy to the Linux kernel's input pool through
+// the RNDADDENTROPY ioctl on /dev/random, which needs CAP_SYS_ADMIN.
+type kernelSink struct {
+	f *os.File
+}
+
+func openKernelSink() (entropySink, error) {
+	f, err := os.OpenFile(randomDevice, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", randomDevice, err)
+	}
+
+	return &kernelSink{f: f}, nil
+}
+
+func (k *kernelSink) EntropyAvail() (int, error) {
+	bits, err := unix.IoctlGetUint32(int(k.f.Fd()), unix.RNDGETENTCNT)
+	if err != nil {
+		return 0, fmt.Errorf("RNDGETENTCNT: %w", err)
+	}
+
+	return int(bits), nil
+}
+
+// Wait polls /dev/random for writability, which the kernel signals
+// when it wants more entropy.
+func (k *kernelSink) Wait(timeout time.Duration) (bool, error) {
+	fds := []unix.PollFd{{Fd: int32(k.f.Fd()), Events: unix.POLLOUT}}
+
+	for {
+		n, err := unix.Poll(fds, int(timeout.Milliseconds()))
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("poll %s: %w", randomDevice, err)
+		}
+
+		return n > 0 && fds[0].Revents&unix.POLLOUT != 0, nil
+	}
+}
+
+// AddEntropy uses RNDADDENTROPY with a struct rand_pool_info:
+//
+//	struct rand_pool_info {
+//		int entropy_count;
+//		int buf_size;
+//		__u32 buf[0];
+//	};
+func (k *kernelSink) AddEntropy(data []byte, bits int) error {
+	info := make([]byte, 8+len(data))
+	binary.NativeEndian.PutUint32(info[0:4], uint32(bits))
+	binary.NativeEndian.PutUint32(info[4:8], uint32(len(data)))
+	copy(info[8:], data)
+
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, k.f.Fd(), uintptr(unix.RNDADDENTROPY), uintptr(unsafe.Pointer(&info[0])))
+	if errno != 0 {
+		return fmt.Errorf("RNDADDENTROPY: %w", errno)
+	}
+
+	return nil
+}
+
+func (k *kernelSink) Close() error {
+	if err := k.f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", randomDevice, err)
+	}
+
+	return nil
+}
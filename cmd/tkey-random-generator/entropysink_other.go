@@ -0,0 +1,12 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func openKernelSink() (entropySink, error) {
+	return nil, fmt.Errorf("feeding the kernel entropy pool is only supported on Linux")
+}
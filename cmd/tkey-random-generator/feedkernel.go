@@ -0,0 +1,229 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// feedOptions are the settings of the feed-kernel subcommand.
+type feedOptions struct {
+	chunk     int
+	credit    int
+	threshold int
+	interval  time.Duration
+	verbose   bool
+	metrics   string
+}
+
+// runFeedKernel is the subcommand continuously crediting entropy from
+// the TKey to the kernel, like rngd. It returns the exit code.
+func runFeedKernel(args []string) int {
+	var dev deviceFlags
+	var opts feedOptions
+	var helpOnly bool
+
+	cmdFeed := pflag.NewFlagSet("feed-kernel", pflag.ExitOnError)
+	cmdFeed.SortFlags = false
+	dev.register(cmdFeed)
+	dev.registerMulti(cmdFeed)
+	cmdFeed.IntVar(&opts.chunk, "chunk", 64,
+		"Write `BYTES` of random data to the kernel at a time.")
+	cmdFeed.IntVar(&opts.credit, "credit", 4,
+		"Credit `BITS` of entropy per byte written, in [0,8].")
+	cmdFeed.IntVar(&opts.threshold, "threshold", 256,
+		"Feed the kernel whenever its entropy estimate is below `BITS`.")
+	cmdFeed.DurationVar(&opts.interval, "interval", time.Minute,
+		"Feed the kernel at least every `DURATION`, even if it doesn't ask for more.")
+	registerMetricsFlag(cmdFeed, &opts.metrics)
+	cmdFeed.BoolVarP(&opts.verbose, "verbose", "v", false, "Log every write to the kernel.")
+	cmdFeed.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdFeed.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s feed-kernel [flags..]
+
+  Keeps the device app loaded and feeds random data from the TKey to
+  the Linux kernel's entropy pool, like rngd. The kernel is fed when
+  its entropy estimate is below the threshold, when it asks for more
+  by waking up writers on /dev/random, and at least every interval.
+
+  All data is run through continuous health tests before it's given
+  to the kernel. If a test fails, the data is dropped and the feeder
+  exits with an error.
+
+  Needs to run as root, or with CAP_SYS_ADMIN, to credit entropy.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdFeed.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdFeed.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdFeed.Usage()
+		return 0
+	}
+
+	if cmdFeed.NArg() > 0 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdFeed.Args(), " "))
+		cmdFeed.Usage()
+		return 2
+	}
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdFeed.Usage()
+		return 2
+	}
+
+	if err := opts.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdFeed.Usage()
+		return 2
+	}
+
+	if err := feedKernelFromTKey(dev, opts); err != nil {
+		le.Printf("Error feeding kernel: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func (o feedOptions) validate() error {
+	if o.chunk < 1 || o.chunk > 4096 {
+		return fmt.Errorf("--chunk needs to be in [1,4096]")
+	}
+
+	if o.credit < 0 || o.credit > 8 {
+		return fmt.Errorf("--credit needs to be in [0,8]")
+	}
+
+	if o.interval < time.Second {
+		return fmt.Errorf("--interval needs to be at least 1s")
+	}
+
+	return nil
+}
+
+func feedKernelFromTKey(dev deviceFlags, opts feedOptions) error {
+	sink, err := openKernelSink()
+	if err != nil {
+		return err
+	}
+	defer sink.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	device, err := dev.superviseAll(ctx)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	if err := serveMetrics(ctx, opts.metrics); err != nil {
+		return err
+	}
+
+	le.Printf("Feeding the kernel entropy pool...\n")
+	notifyReady(ctx, "Feeding the kernel entropy pool")
+	err = feedKernel(ctx, device, sink, opts)
+
+	// Re-init the hash on the TKey
+	device.endSessions()
+
+	return err
+}
+
+// feedKernel reads random data from r and writes it to sink until
+// ctx is done, or reading, writing or a health test fails.
+func feedKernel(ctx context.Context, r io.Reader, sink entropySink, opts feedOptions) error {
+	var health healthTester
+	var total int
+
+	buf := make([]byte, opts.chunk)
+	lastFeed := time.Time{}
+
+	for ctx.Err() == nil {
+		avail, err := sink.EntropyAvail()
+		if err != nil {
+			return err
+		}
+
+		if avail >= opts.threshold && time.Since(lastFeed) < opts.interval {
+			// Wait in short steps to notice ctx being done
+			wait := min(opts.interval-time.Since(lastFeed), time.Second)
+			asked, err := sink.Wait(wait)
+			if err != nil {
+				return err
+			}
+
+			if !asked {
+				continue
+			}
+
+			// Some kernels always report /dev/random as
+			// writable, so only feed if the estimate is low.
+			if avail, err = sink.EntropyAvail(); err != nil {
+				return err
+			}
+			if avail >= opts.threshold {
+				sleepCtx(ctx, wait)
+				continue
+			}
+		}
+
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if ctx.Err() != nil {
+				// Interrupted while waiting for the TKey
+				break
+			}
+			return fmt.Errorf("could not read random data: %w", err)
+		}
+
+		if err := health.Check(buf); err != nil {
+			return err
+		}
+
+		if err := sink.AddEntropy(buf, len(buf)*opts.credit); err != nil {
+			return err
+		}
+
+		lastFeed = time.Now()
+		total += len(buf)
+
+		if opts.verbose {
+			le.Printf("Fed %d bytes, crediting %d bits. Estimate was %d bits. Total %d bytes.\n",
+				len(buf), len(buf)*opts.credit, avail, total)
+		}
+	}
+
+	le.Printf("Stopped after feeding %d bytes.\n", total)
+
+	return nil
+}
+
+// sleepCtx sleeps for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The health tests are the Repetition Count Test and the Adaptive
+// Proportion Test from NIST SP 800-90B, section 4.4, applied to the
+// bytes we get from the TKey. The data is DRBG output so it should
+// have close to 8 bits of entropy per byte, but we assume only 2 to
+// keep false alarms at about 2^-20 per test even for long runs. The
+// tests catch a stuck or badly broken device, not a subtly broken
+// one.
+const (
+	// repetitionCutoff is 1 + ceil(20/H) for H = 2.
+	repetitionCutoff = 11
+	// proportionWindow and proportionCutoff are from table 2 of
+	// SP 800-90B for non-binary data and H = 2.
+	proportionWindow = 512
+	proportionCutoff = 177
+	// duplicateBlockSize is the size of the blocks the duplicate
+	// block test compares, the size of a DRBG round on the TKey. It's
+	// fixed, rather than what callers happen to read at a time, so
+	// that small reads don't repeat by chance.
+	duplicateBlockSize = 16
+)
+
+// errHealthTest is returned when data from the TKey fails a health
+// test.
+var errHealthTest = errors.New("health test failed")
+
+// healthTester runs continuous health tests on a stream of random
+// data. The zero value is ready to use.
+type healthTester struct {
+	// Repetition Count Test
+	last    byte
+	repeats int
+	// Adaptive Proportion Test
+	sample    byte
+	matches   int
+	windowPos int
+	// Duplicate block test
+	block     [duplicateBlockSize]byte
+	blockLen  int
+	lastBlock [duplicateBlockSize]byte
+	hasLast   bool
+	started   bool
+}
+
+// Check runs the health tests on the next data of the stream, of any
+// length. It returns an error wrapping errHealthTest if the data fails
+// any of them.
+func (h *healthTester) Check(data []byte) error {
+	err := h.check(data)
+	if err != nil {
+		metrics.healthFailures.Inc()
+	}
+
+	return err
+}
+
+func (h *healthTester) check(data []byte) error {
+	for _, b := range data {
+		if err := h.checkBlock(b); err != nil {
+			return err
+		}
+
+		if !h.started {
+			h.started = true
+			h.last = b
+			h.repeats = 1
+			h.sample = b
+			h.matches = 1
+			h.windowPos = 1
+			continue
+		}
+
+		if b == h.last {
+			h.repeats++
+			if h.repeats >= repetitionCutoff {
+				return fmt.Errorf("%w: byte 0x%02x repeated %d times", errHealthTest, b, h.repeats)
+			}
+		} else {
+			h.last = b
+			h.repeats = 1
+		}
+
+		if h.windowPos == proportionWindow {
+			h.sample = b
+			h.matches = 1
+			h.windowPos = 1
+			continue
+		}
+
+		if b == h.sample {
+			h.matches++
+			if h.matches >= proportionCutoff {
+				return fmt.Errorf("%w: byte 0x%02x seen %d times in %d", errHealthTest, b, h.matches, proportionWindow)
+			}
+		}
+		h.windowPos++
+	}
+
+	return nil
+}
+
+// checkBlock adds b to the current block and, when it's full, checks
+// that it isn't identical to the one before it, like the continuous
+// test of FIPS 140-2.
+func (h *healthTester) checkBlock(b byte) error {
+	h.block[h.blockLen] = b
+	h.blockLen++
+	if h.blockLen < duplicateBlockSize {
+		return nil
+	}
+	h.blockLen = 0
+
+	if h.hasLast && h.block == h.lastBlock {
+		return fmt.Errorf("%w: block of %d bytes repeated", errHealthTest, duplicateBlockSize)
+	}
+	h.lastBlock, h.hasLast = h.block, true
+
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// varied returns n bytes without repeats, blocks or a dominating value
+// to start a stream with.
+func varied(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+
+	return b
+}
+
+// checkChunks runs the health tests on data, size bytes at a time.
+func checkChunks(h *healthTester, data []byte, size int) error {
+	for len(data) > 0 {
+		n := min(size, len(data))
+		if err := h.Check(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+
+	return nil
+}
+
+func TestHealthRepetitionCount(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		repeats int
+		fail    bool
+	}{
+		{repetitionCutoff - 1, false},
+		{repetitionCutoff, true},
+	} {
+		var h healthTester
+		data := append(varied(16), bytes.Repeat([]byte{0xaa}, tc.repeats)...)
+
+		err := checkChunks(&h, data, len(data))
+		if tc.fail != errors.Is(err, errHealthTest) {
+			t.Errorf("%d repeats: got %v, want failure %t", tc.repeats, err, tc.fail)
+		}
+	}
+}
+
+func TestHealthAdaptiveProportion(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		matches int
+		fail    bool
+	}{
+		{proportionCutoff - 1, false},
+		{proportionCutoff, true},
+	} {
+		// The window samples the first byte, then every other byte
+		// is the sample, with distinct bytes in between
+		var data []byte
+		for i := 0; i < tc.matches; i++ {
+			data = append(data, 0xff, byte(i))
+		}
+
+		var h healthTester
+		err := checkChunks(&h, data, 7)
+		if tc.fail != errors.Is(err, errHealthTest) {
+			t.Errorf("%d matches: got %v, want failure %t", tc.matches, err, tc.fail)
+		}
+	}
+}
+
+func TestHealthDuplicateBlock(t *testing.T) {
+	t.Parallel()
+
+	block := make([]byte, duplicateBlockSize)
+	if _, err := rand.Read(block); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repeated blocks are found also when read a byte at a time
+	var h healthTester
+	err := checkChunks(&h, append(bytes.Clone(block), block...), 1)
+	if !errors.Is(err, errHealthTest) {
+		t.Errorf("repeated block: got %v, want failure", err)
+	}
+}
+
+func TestHealthSmallChunks(t *testing.T) {
+	t.Parallel()
+
+	data := make([]byte, 1<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{1, 2, 3, 15, 16, 17, 4096} {
+		var h healthTester
+		if err := checkChunks(&h, data, size); err != nil {
+			t.Errorf("chunks of %d bytes: %v", size, err)
+		}
+	}
+}
+
+// fakeSink is an entropySink always asking for more, collecting what
+// it's given until it has enough.
+type fakeSink struct {
+	data   []byte
+	bits   int
+	enough int
+	stop   context.CancelFunc
+}
+
+func (s *fakeSink) EntropyAvail() (int, error) {
+	return 0, nil
+}
+
+func (s *fakeSink) Wait(time.Duration) (bool, error) {
+	return true, nil
+}
+
+func (s *fakeSink) AddEntropy(data []byte, bits int) error {
+	s.data = append(s.data, data...)
+	s.bits += bits
+	if len(s.data) >= s.enough {
+		s.stop()
+	}
+
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func TestFeedKernel(t *testing.T) {
+	t.Parallel()
+
+	for _, chunk := range []int{1, 16, 4096} {
+		ctx, stop := context.WithCancel(context.Background())
+		sink := &fakeSink{enough: 64 * 1024, stop: stop}
+		opts := feedOptions{chunk: chunk, credit: 8, threshold: 256, interval: time.Second}
+
+		if err := feedKernel(ctx, rand.Reader, sink, opts); err != nil {
+			t.Errorf("--chunk %d: %v", chunk, err)
+		}
+		if len(sink.data) < sink.enough || sink.bits != 8*len(sink.data) {
+			t.Errorf("--chunk %d: fed %d bytes crediting %d bits", chunk, len(sink.data), sink.bits)
+		}
+		stop()
+	}
+}
+
+func TestFeedKernelStuck(t *testing.T) {
+	t.Parallel()
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	// A stuck TKey returning only zeros
+	stuck := io.LimitReader(zeroReader{}, 1<<20)
+	sink := &fakeSink{enough: 1 << 20, stop: stop}
+	opts := feedOptions{chunk: 1, credit: 8, threshold: 256, interval: time.Second}
+
+	err := feedKernel(ctx, stuck, sink, opts)
+	if !errors.Is(err, errHealthTest) {
+		t.Errorf("got %v, want a health test failure", err)
+	}
+	if len(sink.data) >= repetitionCutoff {
+		t.Errorf("fed %d bytes of zeros", len(sink.data))
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,400 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"tkey-random-generator/randverify"
+)
+
+// maxHTTPRequest is the largest number of bytes in one HTTP request.
+const maxHTTPRequest = 1 << 20
+
+// httpOptions are the settings of the http-serve subcommand.
+type httpOptions struct {
+	listen    string
+	tlsCert   string
+	tlsKey    string
+	tokenFile string
+	verbose   bool
+	metrics   string
+}
+
+// httpDevice is what the HTTP API needs from a TKey.
+type httpDevice interface {
+	io.Reader
+	Signed(n int, nonce []byte) ([]byte, []byte, []byte, error)
+	Pubkey() []byte
+	App() bundleApp
+	Health() (*SelfTestResult, *Status, error)
+}
+
+// runHTTPServe is the subcommand serving random data over HTTP. It
+// returns the exit code.
+func runHTTPServe(args []string) int {
+	var dev deviceFlags
+	var opts httpOptions
+	var helpOnly bool
+
+	cmdHTTP := pflag.NewFlagSet("http-serve", pflag.ExitOnError)
+	cmdHTTP.SortFlags = false
+	dev.register(cmdHTTP)
+	cmdHTTP.StringVar(&opts.listen, "listen", "127.0.0.1:8080",
+		"Listen on `ADDRESS`, host:port.")
+	cmdHTTP.StringVar(&opts.tlsCert, "tls-cert", "",
+		"Serve HTTPS with the certificate in PEM `FILE`. Needs --tls-key.")
+	cmdHTTP.StringVar(&opts.tlsKey, "tls-key", "",
+		"Serve HTTPS with the private key in PEM `FILE`. Needs --tls-cert.")
+	cmdHTTP.StringVar(&opts.tokenFile, "token-file", "",
+		"Require \"Authorization: Bearer TOKEN\" on all requests, with TOKEN read from `FILE`.")
+	registerMetricsFlag(cmdHTTP, &opts.metrics)
+	cmdHTTP.BoolVarP(&opts.verbose, "verbose", "v", false, "Log requests.")
+	cmdHTTP.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdHTTP.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s http-serve [flags..]
+
+  Keeps the device app loaded and serves random data from the TKey
+  over HTTP. Listens on the loopback interface by default. Uses one
+  TKey only, since /signed is signed by a single key.
+
+  Endpoints:
+
+    GET  /random?n=BYTES&format=hex|base64|binary
+         BYTES of random data, default 32, at most 1 MiB.
+    POST /signed?n=BYTES
+         JSON with BYTES of random data, the BLAKE2s hash of it, the
+         signature of the hash and the public key, all in hex.
+    GET  /pubkey
+         JSON with the public key in hex.
+    GET  /health
+         JSON with the device app version, self-test result and
+         random generator state.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdHTTP.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdHTTP.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdHTTP.Usage()
+		return 0
+	}
+
+	if cmdHTTP.NArg() > 0 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdHTTP.Args(), " "))
+		cmdHTTP.Usage()
+		return 2
+	}
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdHTTP.Usage()
+		return 2
+	}
+
+	if (opts.tlsCert == "") != (opts.tlsKey == "") {
+		le.Printf("Pass both --tls-cert and --tls-key, or neither.\n\n")
+		cmdHTTP.Usage()
+		return 2
+	}
+
+	if err := httpServe(dev, opts); err != nil {
+		le.Printf("Error serving: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func httpServe(dev deviceFlags, opts httpOptions) error {
+	var token string
+	if opts.tokenFile != "" {
+		t, err := os.ReadFile(opts.tokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read token: %w", err)
+		}
+		token = strings.TrimSpace(string(t))
+		if token == "" {
+			return fmt.Errorf("token file %s is empty", opts.tokenFile)
+		}
+	}
+
+	if !isLoopback(opts.listen) && (token == "" || opts.tlsCert == "") {
+		le.Printf("Warning: listening on %s without both TLS and a token\n", opts.listen)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	supervised, err := dev.supervise(ctx)
+	if err != nil {
+		return err
+	}
+
+	device, err := newSharedDevice(supervised)
+	if err != nil {
+		supervised.Close()
+		return err
+	}
+	defer device.Close()
+
+	if err := serveMetrics(ctx, opts.metrics); err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           newHTTPHandler(device, token, opts.verbose),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ln, err := activationListener()
+	if err != nil {
+		return err
+	}
+	if ln == nil {
+		ln, err = net.Listen("tcp", opts.listen)
+		if err != nil {
+			return fmt.Errorf("could not listen on %s: %w", opts.listen, err)
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		sdNotify("STOPPING=1")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	scheme := "http"
+	if opts.tlsCert != "" {
+		scheme = "https"
+	}
+	le.Printf("Serving random data on %s://%s\n", scheme, ln.Addr())
+	notifyReady(ctx, fmt.Sprintf("Serving random data on %s://%s", scheme, ln.Addr()))
+
+	if opts.tlsCert != "" {
+		err = srv.ServeTLS(ln, opts.tlsCert, opts.tlsKey)
+	} else {
+		err = srv.Serve(ln)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not serve: %w", err)
+	}
+
+	le.Printf("Stopped.\n")
+
+	return nil
+}
+
+// isLoopback returns true if the host in address is a loopback
+// address or localhost.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// newHTTPHandler returns the handler of the HTTP API. If token isn't
+// empty every request needs it as a bearer token.
+func newHTTPHandler(device httpDevice, token string, verbose bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /random", func(w http.ResponseWriter, r *http.Request) {
+		n, err := requestedBytes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = "hex"
+		}
+		if format != "hex" && format != "base64" && format != "binary" {
+			http.Error(w, "format must be hex, base64 or binary", http.StatusBadRequest)
+			return
+		}
+
+		data := make([]byte, n)
+		if _, err := device.Read(data); err != nil {
+			le.Printf("Error reading random data: %v\n", err)
+			http.Error(w, "could not get random data", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch format {
+		case "hex":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintf(w, "%x\n", data)
+		case "base64":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprintf(w, "%s\n", base64.StdEncoding.EncodeToString(data))
+		case "binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(data)
+		}
+	})
+
+	mux.HandleFunc("POST /signed", func(w http.ResponseWriter, r *http.Request) {
+		n, err := requestedBytes(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, signature, hash, err := device.Signed(n, nil)
+		if err != nil {
+			le.Printf("Error getting signed random data: %v\n", err)
+			http.Error(w, "could not get signed random data", http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, bundle{
+			Bytes:     len(data),
+			Data:      hex.EncodeToString(data),
+			Hash:      hex.EncodeToString(hash),
+			Signature: hex.EncodeToString(signature),
+			Pubkey:    hex.EncodeToString(device.Pubkey()),
+			Scheme:    randverify.SchemeLegacy,
+			App:       device.App(),
+		})
+	})
+
+	mux.HandleFunc("GET /pubkey", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"pubkey": hex.EncodeToString(device.Pubkey()),
+		})
+	})
+
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) {
+		health := healthResponse{Status: "ok", App: device.App()}
+
+		result, status, err := device.Health()
+		if err != nil {
+			le.Printf("Error getting health: %v\n", err)
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: "unavailable", App: health.App})
+			return
+		}
+
+		if result != nil {
+			health.SelfTest = result.String()
+			if !result.Passed() {
+				health.Status = "failed"
+			}
+		}
+		health.DRBG = status
+
+		code := http.StatusOK
+		if health.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, health)
+	})
+
+	var handler http.Handler = mux
+	if token != "" {
+		handler = requireToken(handler, token)
+	}
+	if verbose {
+		handler = logRequests(handler)
+	}
+
+	return handler
+}
+
+// healthResponse is the JSON returned by /health.
+type healthResponse struct {
+	Status   string    `json:"status"`
+	App      bundleApp `json:"app"`
+	SelfTest string    `json:"selftest,omitempty"`
+	DRBG     *Status   `json:"drbg,omitempty"`
+}
+
+// requestedBytes returns the number of bytes asked for in the query
+// parameter n, which defaults to 32.
+func requestedBytes(r *http.Request) (int, error) {
+	q := r.URL.Query().Get("n")
+	if q == "" {
+		return 32, nil
+	}
+
+	n, err := strconv.Atoi(q)
+	if err != nil || n < 1 || n > maxHTTPRequest {
+		return 0, fmt.Errorf("n must be an integer in [1,%d]", maxHTTPRequest)
+	}
+
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_, _ = w.Write(append(out, '\n'))
+}
+
+// requireToken only lets requests with the bearer token through.
+func requireToken(next http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(bytes.TrimSpace(got), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		le.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tillitis/tkeyclient"
+	"tkey-random-generator/randverify"
+)
+
+// fakeTKey is a tkeyDevice hashing what it returns and signing it,
+// like the device app.
+type fakeTKey struct {
+	mu       sync.Mutex
+	src      io.Reader
+	key      ed25519.PrivateKey
+	h        hash.Hash
+	selfTest SelfTestResult
+}
+
+func newFakeTKey(t *testing.T, src io.Reader) *fakeTKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := randverify.NewHash(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeTKey{src: src, key: key, h: h}
+}
+
+func (f *fakeTKey) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n, err := f.src.Read(p)
+	f.h.Write(p[:n])
+
+	return n, err
+}
+
+func (f *fakeTKey) GetAppNameVersion() (*tkeyclient.NameVersion, error) {
+	return &tkeyclient.NameVersion{Name0: wantAppName0, Name1: wantAppName1, Version: 5}, nil
+}
+
+func (f *fakeTKey) Firmware() *tkeyclient.NameVersion {
+	return nil
+}
+
+func (f *fakeTKey) AppDigest() string {
+	return ""
+}
+
+func (f *fakeTKey) GetPubkey() ([]byte, error) {
+	return f.key.Public().(ed25519.PublicKey), nil
+}
+
+func (f *fakeTKey) GetSignature() ([]byte, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The next session starts unkeyed, like on the device app
+	digest := f.h.Sum(nil)
+	f.h, _ = randverify.NewHash(nil, "")
+
+	return ed25519.Sign(f.key, digest), digest, nil
+}
+
+func (f *fakeTKey) EndSession() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.h, _ = randverify.NewHash(nil, "")
+
+	return nil
+}
+
+func (f *fakeTKey) SetNonce(nonce []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h, err := randverify.NewHash(nonce, "")
+	if err != nil {
+		return err
+	}
+	f.h = h
+
+	return nil
+}
+
+func (f *fakeTKey) SelfTest() (SelfTestResult, error) {
+	return f.selfTest, nil
+}
+
+func (f *fakeTKey) Status() (*Status, error) {
+	return &Status{RNGInitialized: true}, nil
+}
+
+func (f *fakeTKey) Close() error {
+	return nil
+}
+
+// newTestServer serves the HTTP API of tkey, requiring token if not
+// empty.
+func newTestServer(t *testing.T, tkey *fakeTKey, token string) *httptest.Server {
+	t.Helper()
+
+	device, err := newSharedDevice(tkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(newHTTPHandler(device, token, false))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// request does a request to srv, returning the status code and body.
+func request(t *testing.T, srv *httptest.Server, method string, path string, token string) (int, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, body
+}
+
+func TestHTTPRandom(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, newFakeTKey(t, rand.Reader), "")
+
+	for _, tc := range []struct {
+		path string
+		code int
+		size int
+	}{
+		{"/random", http.StatusOK, 2*32 + 1},
+		{"/random?n=16&format=base64", http.StatusOK, 25},
+		{"/random?n=100&format=binary", http.StatusOK, 100},
+		{"/random?n=0", http.StatusBadRequest, -1},
+		{"/random?n=2000000", http.StatusBadRequest, -1},
+		{"/random?format=decimal", http.StatusBadRequest, -1},
+	} {
+		code, body := request(t, srv, "GET", tc.path, "")
+		if code != tc.code {
+			t.Errorf("%s: status %d, want %d", tc.path, code, tc.code)
+		}
+		if tc.size >= 0 && len(body) != tc.size {
+			t.Errorf("%s: %d bytes, want %d", tc.path, len(body), tc.size)
+		}
+	}
+}
+
+func TestHTTPRandomSmall(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, newFakeTKey(t, rand.Reader), "")
+
+	// Small reads must not fail the duplicate block test by chance
+	for i := 0; i < 2000; i++ {
+		if code, body := request(t, srv, "GET", "/random?n=1", ""); code != http.StatusOK {
+			t.Fatalf("request %d: status %d: %s", i, code, body)
+		}
+	}
+}
+
+func TestHTTPRandomStuck(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, newFakeTKey(t, zeroReader{}), "")
+
+	code, _ := request(t, srv, "GET", "/random?n=64", "")
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHTTPSigned(t *testing.T) {
+	t.Parallel()
+
+	tkey := newFakeTKey(t, rand.Reader)
+	srv := newTestServer(t, tkey, "")
+
+	// Unsigned data read before must not end up in the signed session
+	if code, _ := request(t, srv, "GET", "/random?n=10", ""); code != http.StatusOK {
+		t.Fatalf("/random: status %d", code)
+	}
+
+	code, body := request(t, srv, "POST", "/signed?n=100", "")
+	if code != http.StatusOK {
+		t.Fatalf("/signed: status %d: %s", code, body)
+	}
+
+	var b bundle
+	if err := json.Unmarshal(body, &b); err != nil {
+		t.Fatal(err)
+	}
+
+	pubkey, _ := tkey.GetPubkey()
+	if b.Bytes != 100 || b.Pubkey != hex.EncodeToString(pubkey) {
+		t.Errorf("got %d bytes signed by %s", b.Bytes, b.Pubkey)
+	}
+
+	res, err := randverify.VerifyBundle(&b, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Problems) > 0 {
+		t.Errorf("bundle not verified: %v", res.Problems)
+	}
+}
+
+func TestHTTPToken(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestServer(t, newFakeTKey(t, rand.Reader), "secret")
+
+	for _, tc := range []struct {
+		token string
+		code  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusOK},
+	} {
+		if code, _ := request(t, srv, "GET", "/pubkey", tc.token); code != tc.code {
+			t.Errorf("token %q: status %d, want %d", tc.token, code, tc.code)
+		}
+	}
+}
+
+func TestHTTPHealth(t *testing.T) {
+	t.Parallel()
+
+	tkey := newFakeTKey(t, rand.Reader)
+	srv := newTestServer(t, tkey, "")
+
+	for _, tc := range []struct {
+		selfTest SelfTestResult
+		code     int
+		status   string
+	}{
+		{0, http.StatusOK, "ok"},
+		{SelfTestEd25519Sign, http.StatusServiceUnavailable, "failed"},
+	} {
+		tkey.selfTest = tc.selfTest
+
+		code, body := request(t, srv, "GET", "/health", "")
+		var health healthResponse
+		if err := json.Unmarshal(body, &health); err != nil {
+			t.Fatal(err)
+		}
+
+		if code != tc.code || health.Status != tc.status || !strings.HasPrefix(health.App.Name, wantAppName0) {
+			t.Errorf("self-test %v: status %d, %q, app %q, want %d, %q", tc.selfTest, code, health.Status, health.App.Name, tc.code, tc.status)
+		}
+	}
+}
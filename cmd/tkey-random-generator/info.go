@@ -0,0 +1,125 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// runInfo is the subcommand showing information about the device app
+// and the state of its DRBG. It returns the exit code.
+func runInfo(args []string) int {
+	var dev deviceFlags
+	var helpOnly bool
+
+	cmdInfo := pflag.NewFlagSet("info", pflag.ExitOnError)
+	cmdInfo.SortFlags = false
+	dev.register(cmdInfo)
+	cmdInfo.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdInfo.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s info [flags..]
+
+  Loads the device app, if not already running, and shows its name,
+  version, public key, the result of its self-test at start and the
+  state of the random generator: whether it has been seeded, how many
+  requests it has served, where it is in the reseed interval and how
+  many bytes are in the current signature session.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdInfo.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdInfo.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdInfo.Usage()
+		return 0
+	}
+
+	if cmdInfo.NArg() > 0 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdInfo.Args(), " "))
+		cmdInfo.Usage()
+		return 2
+	}
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdInfo.Usage()
+		return 2
+	}
+
+	if err := info(dev); err != nil {
+		le.Printf("Error getting info: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func info(dev deviceFlags) error {
+	randomGen, err := dev.connect()
+	if err != nil {
+		return err
+	}
+	defer randomGen.Close()
+
+	nameVer, err := randomGen.GetAppNameVersion()
+	if err != nil {
+		return fmt.Errorf("GetAppNameVersion failed: %w", err)
+	}
+
+	pubkey, err := randomGen.GetPubkey()
+	if err != nil {
+		return fmt.Errorf("GetPubkey failed: %w", err)
+	}
+
+	fmt.Printf("App name: %s%s\n", nameVer.Name0, nameVer.Name1)
+	fmt.Printf("App version: %d\n", nameVer.Version)
+	if digest := randomGen.AppDigest(); digest != "" {
+		fmt.Printf("App SHA512: %s\n", digest)
+	} else {
+		fmt.Printf("App SHA512: unknown, the app was already running\n")
+	}
+	fmt.Printf("Public key: %x\n", pubkey)
+
+	if nameVer.Version < appVersionExtended {
+		fmt.Printf("Self-test: not supported by device app version %d, load a newer one with --app\n", nameVer.Version)
+		fmt.Printf("Status: not supported by device app version %d, load a newer one with --app\n", nameVer.Version)
+		return nil
+	}
+
+	result, err := randomGen.SelfTest()
+	if err != nil {
+		return fmt.Errorf("SelfTest failed: %w", err)
+	}
+
+	fmt.Printf("Self-test: %s\n", result)
+
+	status, err := randomGen.Status()
+	if err != nil {
+		return fmt.Errorf("Status failed: %w", err)
+	}
+
+	fmt.Printf("RNG initialized: %t\n", status.RNGInitialized)
+	fmt.Printf("Generate calls: %d\n", status.GenerateCalls)
+	fmt.Printf("Reseed counter: %d / %d\n", status.ReseedCounter, status.ReseedInterval)
+	fmt.Printf("Reseeds: %d\n", status.Reseeds)
+	fmt.Printf("Session bytes: %d\n", status.SessionBytes)
+
+	if status.TouchTimeout == 0 {
+		fmt.Printf("Touch to sign: not required\n")
+	} else {
+		fmt.Printf("Touch to sign: required, waiting at most %d s\n", status.TouchTimeout)
+	}
+
+	return nil
+}
@@ -5,8 +5,8 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
-	"crypto/sha512"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -14,6 +14,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -22,8 +23,8 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/spf13/pflag"
 	"github.com/tillitis/tkeyclient"
-	"github.com/tillitis/tkeyutil"
-	"golang.org/x/crypto/blake2s"
+	"tkey-random-generator/randsock"
+	"tkey-random-generator/randverify"
 )
 
 const (
@@ -37,10 +38,23 @@ var le = log.New(os.Stderr, "", 0)
 
 var version string
 
+// exitInterrupted is the exit code of generate when interrupted, after
+// signing what was generated so far.
+const exitInterrupted = 3
+
+// errInterrupted is returned by generate when interrupted.
+var errInterrupted = errors.New("interrupted")
+
 func main() {
-	var fileUSS, devPath, filePath, fileRandData, fileSignature, filePubkey string
-	var speed, genBytes int
-	var enterUSS, forceFullUSS, helpOnlyGen, helpOnlyVerify, shouldSign, verbose, isBinary, versionOnly bool
+	var fileRandData, fileSignature, filePubkey string
+	var helpOnlyGen, helpOnlyVerify, isBinary, versionOnly bool
+	var opts generateOptions
+	var mixValues []string
+	var nonceValue, verifyNonce, verifyContext string
+	var segmentSize, verifyManifest, verifyRange, verifyTranscript string
+	var verifyBatch, verifyReport string
+	var verifyPubkeys []string
+	var verifyJobs int
 
 	genString := "generate"
 	verifyString := "verify"
@@ -71,6 +85,13 @@ Usage:
 Commands:
   generate    Generate random data
   verify      Verify signature of previously generated data
+  info        Show device app information and random generator state
+  feed-kernel Feed the Linux kernel entropy pool, like rngd
+  serve       Serve random data to other processes over a Unix socket
+  http-serve  Serve random data and signed random data over HTTP
+  egd         Serve random data over a Unix socket with the EGD protocol
+  beacon      Emit and verify a hash-chained beacon of signed pulses
+  log         Verify the audit log of signatures
 
 Use <command> --help for further help, i.e. %[1]s verify --help
 
@@ -82,21 +103,39 @@ Flags:`, os.Args[0])
 	// Flag for command "generate"
 	cmdGen := pflag.NewFlagSet(genString, pflag.ExitOnError)
 	cmdGen.SortFlags = false
-	cmdGen.StringVarP(&devPath, "port", "p", "",
-		"Set serial port device `PATH`. If this is not passed, auto-detection will be attempted.")
-	cmdGen.IntVar(&speed, "speed", tkeyclient.SerialSpeed,
-		"Set serial port speed in `BPS` (bits per second).")
-	cmdGen.BoolVarP(&shouldSign, "signature", "s", false, "Get the signature of the generated random data.")
-	cmdGen.StringVarP(&filePath, "file", "f", "",
+	opts.dev.register(cmdGen)
+	opts.dev.registerMulti(cmdGen)
+	cmdGen.BoolVarP(&opts.shouldSign, "signature", "s", false, "Get the signature of the generated random data.")
+	cmdGen.StringVarP(&opts.filePath, "file", "f", "",
 		"Output random data as binary to `FILE`.")
+	cmdGen.BoolVar(&opts.force, "force", false,
+		"Overwrite FILE, and the --mix-raw, --json and --segment-manifest FILEs, if they exist.")
+	cmdGen.StringVarP(&opts.jsonPath, "json", "j", "",
+		"Write a JSON summary of the run, including signature, public key and reseed policy, to `FILE`. Use '-' (dash) for stdout.")
+	cmdGen.StringVar(&nonceValue, "nonce", "",
+		fmt.Sprintf("Key the signed hash with `NONCE`, 1 to %d bytes in hex, typically a challenge from whoever verifies, proving the data was generated after it was known. Use random for a random nonce.", NonceMaxBytes))
+	cmdGen.StringVar(&opts.context, "context", "",
+		fmt.Sprintf("Bind the context `LABEL`, at most %d bytes, into the signed hash, like \"lottery draw 2026-10-18\", so the signature can't be used for another purpose.", ContextMaxBytes))
+	cmdGen.StringVar(&segmentSize, "segment-size", "",
+		"Sign every `SIZE` bytes, like 1GiB, on its own, so a damaged part doesn't make the rest unverifiable. Needs -s and --segment-manifest.")
+	cmdGen.StringVar(&opts.segmentManifest, "segment-manifest", "",
+		"Write the offset, length, hash and signature of every segment to `FILE`.")
+	cmdGen.Uint32Var(&opts.reseedEvery, "reseed-every", 0,
+		fmt.Sprintf("Make the TKey reseed its generator from the TRNG every `ROUNDS` rounds of 16 bytes, in [1,%d]. Lasts until the TKey is unplugged.", MaxReseedInterval))
+	cmdGen.BoolVar(&opts.reseedBefore, "reseed-before", false,
+		"Make the TKey reseed its generator from the TRNG right before generating.")
+	cmdGen.StringVar(&opts.socket, "socket", "",
+		"Fetch the random data from a serve daemon listening on `PATH`, falling back to the TKey if there is none. The data is not signed.")
+	cmdGen.StringSliceVar(&mixValues, "mix", nil,
+		"Mix the TKey output with other `SOURCES`: os for the OS random generator, file:PATH for a file of extra entropy, or both separated by a comma. The signature is of the TKey output before mixing.")
+	cmdGen.StringVar(&opts.mixRaw, "mix-raw", "",
+		"Write the TKey output before mixing, which the signature is of, to `FILE`. Keep it as secret as the output.")
+	cmdGen.StringVar(&opts.auditLog, "audit-log", "",
+		"Append every signature the TKey made to the audit log `FILE`, chaining the entries so changes are detected. Needs -s.")
+	cmdGen.StringVar(&opts.metricsFile, "metrics-file", "",
+		"Write Prometheus metrics of the run to `FILE`, for the textfile collector of the node exporter. Written also if the run fails.")
 	cmdGen.BoolVarP(&helpOnlyGen, "help", "h", false, "Output this help.")
-	cmdGen.BoolVar(&enterUSS, "uss", false,
-		"Enable typing of a phrase to be hashed as the User Supplied Secret. The USS is loaded onto the TKey along with the app itself. A different USS results in different Compound Device Identifier, different start of the random sequence, and another key pair used for signing.")
-	cmdGen.StringVar(&fileUSS, "uss-file", "",
-		"Read `FILE` and hash its contents as the USS. Use '-' (dash) to read from stdin. The full contents are hashed unmodified (e.g. newlines are not stripped).")
-	cmdGen.BoolVar(&forceFullUSS, "force-full-uss", false,
-		"Use 32 byte USS digest. Default is 31.")
-	cmdGen.BoolVarP(&verbose, "verbose", "v", false, "Be more verbose")
+	cmdGen.BoolVarP(&opts.verbose, "verbose", "v", false, "Be more verbose")
 	cmdGen.Usage = func() {
 		desc := fmt.Sprintf(`Usage %[1]s generate <bytes> [-s] [--uss] [flags..]
 
@@ -115,9 +154,30 @@ Flags:`, os.Args[0])
 	cmdVerify := pflag.NewFlagSet(verifyString, pflag.ExitOnError)
 	cmdVerify.SortFlags = false
 	cmdVerify.BoolVarP(&isBinary, "binary", "b", false, "Specify if the input FILE is in binary format.")
+	cmdVerify.StringVar(&verifyNonce, "nonce", "",
+		"The data was generated with `NONCE`, in hex. Verifies that the hash was keyed with it.")
+	cmdVerify.StringVar(&verifyContext, "context", "",
+		"The data was generated with the context `LABEL`. Without it, the legacy signature scheme is verified.")
+	cmdVerify.StringVar(&verifyManifest, "segment-manifest", "",
+		"Verify every segment listed in `MANIFEST`, written by generate --segment-manifest, instead of a SIG-FILE, and list those failing.")
+	cmdVerify.StringVar(&verifyRange, "range", "",
+		"With --segment-manifest, verify only the segments with bytes in `OFFSET:LENGTH` of the data.")
+	cmdVerify.StringVar(&verifyTranscript, "transcript", "",
+		"Verify the saved output of generate -s in `TRANSCRIPT`, with the random data in hex, public key, signature and hash, instead of FILE and SIG-FILE. Use '-' (dash) for stdin.")
+	cmdVerify.StringVar(&verifyBatch, "manifest", "",
+		"Verify every item listed in `FILE`, or found in it if a directory, in parallel, instead of FILE, SIG-FILE and PUBKEY-FILE.")
+	cmdVerify.StringArrayVar(&verifyPubkeys, "pubkey", nil,
+		"With --manifest, trust the public key in `PUBKEY-FILE`, and report items signed by any other. Pass several times for several TKeys.")
+	cmdVerify.IntVar(&verifyJobs, "jobs", 0,
+		"With --manifest, verify `N` items at a time. Defaults to the number of CPUs.")
+	cmdVerify.StringVar(&verifyReport, "report", "",
+		"With --manifest, write a JSON report with the status of every item to `FILE`. Use '-' (dash) for stdout.")
 	cmdVerify.BoolVarP(&helpOnlyVerify, "help", "h", false, "Output this help.")
 	cmdVerify.Usage = func() {
 		desc := fmt.Sprintf(`Usage: %[1]s verify FILE SIG-FILE PUBKEY-FILE [-b]
+       %[1]s verify --segment-manifest MANIFEST FILE PUBKEY-FILE [-b]
+       %[1]s verify --transcript TRANSCRIPT [PUBKEY-FILE]
+       %[1]s verify --manifest FILE|DIR [--pubkey PUBKEY-FILE]...
 
   Verifies whether the Ed25519 signature of the message is valid.
   Does not need a connected TKey to verify.
@@ -129,8 +189,28 @@ Flags:`, os.Args[0])
   SIG-FILE is expected to be an 64 bytes Ed25519 signature in hex.
   PUBKEY-FILE is expected to be an 32 bytes Ed25519 public key in hex.
 
-  The return value is 0 if the signature is valid, otherwise non-zero.
-  Newlines will be striped from the input files. `, os.Args[0])
+  Each of FILE, SIG-FILE and PUBKEY-FILE can also be '-' (dash) for
+  stdin, or given inline as hex:VALUE or base64:VALUE. Hex and base64
+  are both accepted and told apart automatically. Whitespace, line
+  breaks, including CRLF, and a label before a colon, like the
+  "Signature: " printed by generate, are ignored.
+
+  With --segment-manifest, every segment of FILE is verified with its
+  signature in MANIFEST, or only those in a range with --range.
+
+  With --transcript, the random data, public key, signature and hash
+  are taken from the saved output of generate -s, and checked to be
+  consistent. The public key is checked against PUBKEY-FILE, if given,
+  and the nonce and context against --nonce and --context.
+
+  With --manifest, every item listed in the JSON manifest FILE is
+  verified: a bundle written by generate --json, or random data with
+  its signature and public key. With a directory DIR, every bundle
+  and every FILE.sig found in it are verified instead. A table of the
+  status of every item is printed, and with --report a JSON report
+  written. The return value is 0 only if all items are verified.
+
+  The return value is 0 if the signature is valid, otherwise non-zero.`, os.Args[0])
 		le.Printf("%s\n\n%s", desc,
 			cmdVerify.FlagUsagesWrapped(86))
 	}
@@ -168,14 +248,98 @@ Flags:`, os.Args[0])
 			os.Exit(0)
 		}
 
-		if enterUSS && fileUSS != "" {
-			le.Printf("Pass only one of --uss or --uss-file.\n\n")
-			pflag.Usage()
+		if err := opts.dev.validate(); err != nil {
+			le.Printf("%v\n\n", err)
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if cmdGen.Changed("reseed-every") && (opts.reseedEvery < 1 || opts.reseedEvery > MaxReseedInterval) {
+			le.Printf("--reseed-every needs to be in [1,%d].\n\n", MaxReseedInterval)
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		var err error
+		opts.mix, err = parseMixSources(mixValues)
+		if err != nil {
+			le.Printf("%v\n\n", err)
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		opts.nonce, err = parseNonce(nonceValue, true)
+		if err != nil {
+			le.Printf("%v\n\n", err)
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if err := checkContext(opts.context); err != nil {
+			le.Printf("%v\n\n", err)
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if (segmentSize != "") != (opts.segmentManifest != "") {
+			le.Printf("--segment-size and --segment-manifest need each other.\n\n")
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if segmentSize != "" {
+			opts.segmentSize, err = parseSegmentSize(segmentSize)
+			if err != nil {
+				le.Printf("%v\n\n", err)
+				cmdGen.Usage()
+				os.Exit(2)
+			}
+
+			if !opts.shouldSign {
+				le.Printf("--segment-size needs -s.\n\n")
+				cmdGen.Usage()
+				os.Exit(2)
+			}
+
+			if opts.dev.several() {
+				le.Printf("--segment-size can't be used with --multi.\n\n")
+				cmdGen.Usage()
+				os.Exit(2)
+			}
+		}
+
+		if opts.auditLog != "" && !opts.shouldSign {
+			le.Printf("--audit-log needs -s.\n\n")
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if opts.socket != "" && (opts.shouldSign || opts.jsonPath != "" || opts.reseedEvery != 0 || opts.reseedBefore || len(mixValues) > 0 || opts.dev.several() || opts.nonce != nil || opts.context != "") {
+			le.Printf("--socket can't be used with -s, --json, --reseed-every, --reseed-before, --mix, --multi, --nonce or --context.\n\n")
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if opts.dev.several() && len(mixValues) > 0 {
+			le.Printf("--mix can't be used with --multi.\n\n")
+			cmdGen.Usage()
 			os.Exit(2)
 		}
 
-		if forceFullUSS && fileUSS == "" != enterUSS {
-			le.Printf("--force-full-uss unusable unless you also specify --uss or --uss-file.\n\n")
+		if opts.dev.several() && opts.shouldSign && opts.jsonPath == "" {
+			le.Printf("-s with --multi needs --json to record what each TKey signed.\n\n")
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if opts.mixRaw != "" && len(mixValues) == 0 {
+			le.Printf("--mix-raw needs --mix.\n\n")
+			cmdGen.Usage()
+			os.Exit(2)
+		}
+
+		if opts.shouldSign && len(mixValues) > 0 && opts.mixRaw == "" && opts.jsonPath == "" {
+			le.Printf("-s with --mix needs --mix-raw or --json to keep the TKey output the signature is of.\n\n")
 			cmdGen.Usage()
 			os.Exit(2)
 		}
@@ -190,15 +354,22 @@ Flags:`, os.Args[0])
 			os.Exit(2)
 		}
 
-		var err error
-		genBytes, err = strconv.Atoi(cmdGen.Args()[0])
-		if err != nil || genBytes < 1 {
+		opts.genBytes, err = strconv.Atoi(cmdGen.Args()[0])
+		if err != nil || opts.genBytes < 1 {
 			le.Printf("Argument needs to be an integer larger than 0.\n\n")
 			cmdGen.Usage()
 			os.Exit(2)
 		}
 
-		err = generate(devPath, enterUSS, fileUSS, forceFullUSS, speed, genBytes, filePath, shouldSign, verbose)
+		err = generate(opts)
+		if opts.metricsFile != "" {
+			if metricsErr := writeMetricsFile(opts.metricsFile, err == nil); metricsErr != nil {
+				le.Printf("%v\n", metricsErr)
+			}
+		}
+		if errors.Is(err, errInterrupted) {
+			os.Exit(exitInterrupted)
+		}
 		if err != nil {
 			le.Printf("Error generating random data: %v\n", err)
 			os.Exit(1)
@@ -216,27 +387,138 @@ Flags:`, os.Args[0])
 			os.Exit(0)
 		}
 
-		if cmdVerify.NArg() < 3 {
-			le.Printf("Missing %d input file(s) to verify signature.\n\n", 3-cmdVerify.NArg())
+		// The manifest replaces SIG-FILE, the transcript all but the
+		// optional PUBKEY-FILE, and the batch manifest all
+		nFiles := 3
+		if verifyManifest != "" {
+			nFiles = 2
+		}
+
+		if verifyTranscript != "" {
+			if verifyManifest != "" || isBinary {
+				le.Printf("--transcript can't be used with --segment-manifest or -b.\n\n")
+				cmdVerify.Usage()
+				os.Exit(2)
+			}
+			nFiles = min(cmdVerify.NArg(), 1)
+		}
+
+		if verifyBatch != "" {
+			nFiles = 0
+		}
+
+		if cmdVerify.NArg() < nFiles {
+			le.Printf("Missing %d input file(s) to verify signature.\n\n", nFiles-cmdVerify.NArg())
+			cmdVerify.Usage()
+			os.Exit(2)
+		} else if cmdVerify.NArg() > nFiles {
+			le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdVerify.Args()[nFiles:], " "))
 			cmdVerify.Usage()
 			os.Exit(2)
-		} else if cmdVerify.NArg() > 3 {
-			le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdVerify.Args()[3:], " "))
+		}
+
+		// stdin can only be read once
+		stdin := 0
+		for _, arg := range append(cmdVerify.Args(), verifyTranscript) {
+			if arg == "-" {
+				stdin++
+			}
+		}
+		if stdin > 1 {
+			le.Printf("Only one input can be '-' (dash) for stdin.\n\n")
 			cmdVerify.Usage()
 			os.Exit(2)
 		}
+
+		if verifyBatch != "" {
+			if verifyManifest != "" || verifyTranscript != "" || verifyRange != "" || isBinary {
+				le.Printf("--manifest can't be used with --segment-manifest, --transcript, --range or -b.\n\n")
+				cmdVerify.Usage()
+				os.Exit(2)
+			}
+		} else if len(verifyPubkeys) > 0 || verifyReport != "" || cmdVerify.Changed("jobs") {
+			le.Printf("--pubkey, --jobs and --report need --manifest.\n\n")
+			cmdVerify.Usage()
+			os.Exit(2)
+		}
+
+		if verifyJobs < 0 {
+			le.Printf("--jobs needs to be at least 1.\n\n")
+			cmdVerify.Usage()
+			os.Exit(2)
+		} else if verifyJobs == 0 {
+			verifyJobs = runtime.NumCPU()
+		}
+
+		if verifyRange != "" && verifyManifest == "" {
+			le.Printf("--range needs --segment-manifest.\n\n")
+			cmdVerify.Usage()
+			os.Exit(2)
+		}
+
+		nonce, err := parseNonce(verifyNonce, false)
+		if err == nil {
+			err = checkContext(verifyContext)
+		}
+		if err != nil {
+			le.Printf("%v\n\n", err)
+			cmdVerify.Usage()
+			os.Exit(2)
+		}
+
+		if verifyBatch != "" {
+			os.Exit(runVerifyBatch(verifyBatch, verifyPubkeys, verifyJobs, verifyReport, nonce, verifyContext))
+		}
+
+		if verifyTranscript != "" {
+			var filePubkey string
+			if cmdVerify.NArg() > 0 {
+				filePubkey = cmdVerify.Args()[0]
+			}
+
+			os.Exit(runVerifyTranscript(verifyTranscript, filePubkey, nonce, verifyContext))
+		}
+
+		if verifyManifest != "" {
+			var rng *byteRange
+			if verifyRange != "" {
+				rng, err = parseRange(verifyRange)
+				if err != nil {
+					le.Printf("%v\n\n", err)
+					cmdVerify.Usage()
+					os.Exit(2)
+				}
+			}
+
+			os.Exit(runVerifySegments(verifyManifest, cmdVerify.Args()[0], cmdVerify.Args()[1], isBinary, nonce, verifyContext, rng))
+		}
+
 		fileRandData = cmdVerify.Args()[0]
 		fileSignature = cmdVerify.Args()[1]
 		filePubkey = cmdVerify.Args()[2]
 
 		le.Printf("Verifying signature ...\n")
-		if err := verifySignature(fileRandData, fileSignature, filePubkey, isBinary); err != nil {
+		if err := verifySignature(fileRandData, fileSignature, filePubkey, isBinary, nonce, verifyContext); err != nil {
 			le.Printf("Error verifying: %v\n", err)
 			os.Exit(1)
 		}
 		le.Printf("Signature verified.\n")
 
 		os.Exit(0)
+	case "info":
+		os.Exit(runInfo(os.Args[2:]))
+	case "feed-kernel":
+		os.Exit(runFeedKernel(os.Args[2:]))
+	case "serve":
+		os.Exit(runServe(os.Args[2:]))
+	case "http-serve":
+		os.Exit(runHTTPServe(os.Args[2:]))
+	case "egd":
+		os.Exit(runEGD(os.Args[2:]))
+	case "beacon":
+		os.Exit(runBeacon(os.Args[2:]))
+	case "log":
+		os.Exit(runLog(os.Args[2:]))
 	default:
 		root.Usage()
 		le.Printf("%q is not a valid subcommand.\n", os.Args[1])
@@ -256,78 +538,197 @@ https://github.com/tillitis/tkey-random-generator/
 	fmt.Printf("--------------------------------------------------------------------------------\n\n")
 }
 
-// subcommand to generate random data
-func generate(devPath string, enterUSS bool, fileUSS string, forceFullUSS bool, speed int, genBytes int, filePath string, shouldSign bool, verbose bool) error {
-	tkeyclient.SilenceLogging()
+// generateOptions are the settings of the generate subcommand.
+type generateOptions struct {
+	dev          deviceFlags
+	genBytes     int
+	filePath     string
+	shouldSign   bool
+	verbose      bool
+	jsonPath     string
+	reseedEvery  uint32
+	reseedBefore bool
+	socket       string
+	metricsFile  string
+	mix          mixSources
+	mixRaw       string
+	nonce        []byte
+	context      string
+	// segmentSize is the number of bytes signed in every session, or
+	// 0 for one session
+	segmentSize     int64
+	segmentManifest string
+	// auditLog is the audit log to append the signatures to, if any
+	auditLog string
+	// force is true to overwrite existing output files
+	force bool
+}
 
-	if devPath == "" {
-		var err error
-		devPath, err = tkeyclient.DetectSerialPort(true)
-		if err != nil {
-			return fmt.Errorf("DetectSerialPort: %w", err)
+// subcommand to generate random data
+func generate(opts generateOptions) error {
+	// Before touching the TKey
+	for _, path := range []string{opts.filePath, opts.mixRaw, opts.jsonPath, opts.segmentManifest} {
+		if path == "" || path == "-" {
+			continue
+		}
+		if err := checkOutput(path, opts.force); err != nil {
+			return err
 		}
 	}
 
-	tk := tkeyclient.New()
-	le.Printf("Connecting to device on serial port %s...\n", devPath)
-
-	options := []func(*tkeyclient.TillitisKey){}
-
-	if speed != 0 {
-		options = append(options, tkeyclient.WithSpeed(speed))
+	if opts.socket != "" {
+		return generateViaSocket(opts)
 	}
 
-	if forceFullUSS {
-		options = append(options, tkeyclient.WithFullUss())
+	if opts.dev.several() {
+		return generateSeveral(opts)
 	}
 
-	if err := tk.Connect(devPath, options...); err != nil {
-		return fmt.Errorf("could not open %s: %w", devPath, err)
+	randomGen, err := opts.dev.connect()
+	if err != nil {
+		return err
 	}
 
-	randomGen := New(tk)
 	exit := func(code int) {
+		removePendingOutputs()
 		if err := randomGen.Close(); err != nil {
 			le.Printf("%v\n", err)
 		}
 		os.Exit(code)
 	}
-	handleSignals(func() { exit(1) }, os.Interrupt, syscall.SIGTERM)
+	ctx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	handleSignals(interruptOnce(ctx, interrupt, func() { exit(1) }), os.Interrupt, syscall.SIGTERM)
 	defer randomGen.Close()
 
-	err := loadApp(tk, enterUSS, fileUSS)
+	nameVer, err := randomGen.GetAppNameVersion()
 	if err != nil {
-		return fmt.Errorf("couldn't load app: %w", err)
+		return fmt.Errorf("GetAppNameVersion failed: %w", err)
 	}
 
-	if !isWantedApp(randomGen) {
-		return fmt.Errorf("the TKey may already be running an app, but not the expected. Please unplug and plug it in again")
-	}
+	app := appOf(nameVer, randomGen.AppDigest())
 
-	totRandom, err := genRandomData(randomGen, genBytes, filePath, verbose)
+	policy, err := applyReseedPolicy(randomGen, nameVer.Version, opts.reseedEvery, opts.reseedBefore)
 	if err != nil {
+		return err
+	}
+
+	var src io.Reader = randomGen
+	var segs *segmenter
+	if opts.segmentSize > 0 {
+		// Sets the nonce and context of every segment
+		segs, err = newSegmenter(randomGen, nameVer.Version, opts.segmentSize, opts.nonce, opts.context)
+		if err != nil {
+			return err
+		}
+		src = segs
+	} else {
+		if opts.nonce != nil {
+			if err := setNonce(randomGen, nameVer.Version, opts.nonce); err != nil {
+				return err
+			}
+		}
+
+		if opts.context != "" {
+			if err := setContext(randomGen, nameVer.Version, opts.context); err != nil {
+				return err
+			}
+		}
+	}
+
+	var mixer *mixReader
+	if opts.mix.os || opts.mix.file != "" {
+		mixer, err = newMixReader(src, opts.mix, opts.genBytes)
+		if err != nil {
+			return err
+		}
+		src = mixer
+		le.Printf("Mixing the TKey output with: %s\n", strings.Join(opts.mix.names()[1:], ", "))
+	}
+
+	totRandom, err := genRandomData(ctx, src, opts.genBytes, opts.filePath, opts.force, opts.verbose)
+	interrupted := errors.Is(err, errInterrupted)
+	if interrupted {
+		le.Printf("Interrupted after %d of %d bytes.\n", len(totRandom), opts.genBytes)
+		if len(totRandom) == 0 {
+			return err
+		}
+	} else if err != nil {
 		return fmt.Errorf("genRandomData failed: %w", err)
 	}
 
-	// Always fetch the signature and hash to re-init the hash on the TKey
-	signature, hash, err := randomGen.GetSignature()
-	if err != nil {
-		return fmt.Errorf("GetSig failed: %w", err)
+	// What the TKey hashed and signed
+	tkeyRandom := totRandom
+	if mixer != nil {
+		tkeyRandom = mixer.raw
+
+		if opts.mixRaw != "" {
+			if err := writeOutput(opts.mixRaw, tkeyRandom, opts.force); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Only print and verify if asked
-	if shouldSign {
-		pubkey, err := randomGen.GetPubkey()
+	var signature, hash, pubkey []byte
+
+	if segs != nil {
+		// Every segment was signed, and verified, as it was read
+		dataFile := opts.filePath
+		if mixer != nil {
+			dataFile = opts.mixRaw
+		}
+
+		manifest, err := segs.finish(dataFile)
+		if err != nil {
+			return err
+		}
+		if interrupted {
+			manifest.Interrupted = true
+			manifest.Requested = int64(opts.genBytes)
+		}
+		pubkey = segs.pubkey
+
+		if err := writeJSONFile(opts.segmentManifest, manifest, opts.force); err != nil {
+			return err
+		}
+
+		fmt.Printf("Public key: %x\n", pubkey)
+		if opts.nonce != nil {
+			fmt.Printf("Nonce: %x\n", opts.nonce)
+		}
+		if opts.context != "" {
+			fmt.Printf("Context: %s\n", opts.context)
+		}
+		fmt.Printf("Segments: %d, signatures written to %s\n", len(manifest.Segments), opts.segmentManifest)
+		le.Printf("All segment signatures verified.\n")
+	} else if !opts.shouldSign {
+		// Re-init the hash on the TKey
+		if err := endSession(randomGen, nameVer.Version); err != nil {
+			return err
+		}
+	} else {
+		signature, hash, err = randomGen.GetSignature()
+		if err != nil {
+			return fmt.Errorf("GetSig failed: %w", err)
+		}
+
+		pubkey, err = randomGen.GetPubkey()
 		if err != nil {
 			return fmt.Errorf("GetPubkey failed: %w", err)
 		}
 
 		fmt.Printf("Public key: %x\n", pubkey)
 		fmt.Printf("Signature: %x\n", signature)
+		if opts.nonce != nil {
+			fmt.Printf("Nonce: %x\n", opts.nonce)
+		}
+		if opts.context != "" {
+			fmt.Printf("Context: %s\n", opts.context)
+		}
 		fmt.Printf("Hash: %x\n", hash)
 
 		// Do we compute the same hash digest as random-generator did?
-		errHash := verifyHash(hash, totRandom)
+		errHash := randverify.VerifyHash(hash, bytes.NewReader(tkeyRandom), opts.nonce, opts.context)
 		if errHash != nil {
 			return fmt.Errorf("hash FAILED verification: %w", errHash)
 		}
@@ -339,9 +740,162 @@ func generate(devPath string, enterUSS bool, fileUSS string, forceFullUSS bool,
 		le.Printf("signature verified.\n")
 	}
 
+	if opts.auditLog != "" {
+		entry := func(n int64, hash []byte, signature []byte) *auditEntry {
+			return &auditEntry{
+				Command:   "generate",
+				Bytes:     n,
+				Hash:      hash,
+				Signature: signature,
+				Pubkey:    pubkey,
+				Nonce:     opts.nonce,
+				Scheme:    randverify.Scheme(opts.context),
+				Context:   opts.context,
+				App:       app,
+				Firmware:  firmwareApp(randomGen.Firmware()),
+			}
+		}
+
+		var entries []*auditEntry
+		if segs != nil {
+			for _, seg := range segs.segments {
+				hash, err := hex.DecodeString(seg.Hash)
+				if err != nil {
+					return fmt.Errorf("bad hash of segment at offset %d: %w", seg.Offset, err)
+				}
+				signature, err := hex.DecodeString(seg.Signature)
+				if err != nil {
+					return fmt.Errorf("bad signature of segment at offset %d: %w", seg.Offset, err)
+				}
+				entries = append(entries, entry(seg.Length, hash, signature))
+			}
+		} else {
+			entries = append(entries, entry(int64(len(tkeyRandom)), hash, signature))
+		}
+
+		if err := appendAudit(opts.auditLog, entries...); err != nil {
+			return err
+		}
+	}
+
+	if opts.jsonPath != "" {
+		b := bundle{
+			Bytes:  len(totRandom),
+			File:   opts.filePath,
+			App:    app,
+			Reseed: policy,
+		}
+		if interrupted {
+			b.Interrupted = true
+			b.Requested = opts.genBytes
+		}
+		if opts.filePath == "" {
+			b.Data = hex.EncodeToString(totRandom)
+		}
+		if opts.nonce != nil {
+			b.Nonce = hex.EncodeToString(opts.nonce)
+		}
+		if opts.shouldSign {
+			b.Scheme = randverify.Scheme(opts.context)
+			b.Context = opts.context
+		}
+		if mixer != nil {
+			b.Mix = &mixInfo{
+				Extractor: mixExtractor,
+				Sources:   opts.mix.names(),
+				TKeyFile:  opts.mixRaw,
+			}
+			if opts.mixRaw == "" {
+				b.Mix.TKeyData = hex.EncodeToString(tkeyRandom)
+			}
+		}
+		if opts.shouldSign {
+			b.SegmentManifest = opts.segmentManifest
+			b.Hash = hex.EncodeToString(hash)
+			b.Signature = hex.EncodeToString(signature)
+			b.Pubkey = hex.EncodeToString(pubkey)
+		}
+
+		if err := writeBundle(opts.jsonPath, b, opts.force); err != nil {
+			return err
+		}
+	}
+
+	if interrupted {
+		return errInterrupted
+	}
+
 	return nil
 }
 
+// generateViaSocket fetches the random data from a serve daemon, or
+// directly from the TKey if there is no daemon. The data isn't
+// signed.
+func generateViaSocket(opts generateOptions) error {
+	src, viaDaemon, err := randsock.Open(opts.socket, func() (io.ReadCloser, error) {
+		le.Printf("No daemon on %s, using the TKey directly\n", opts.socket)
+		randomGen, err := opts.dev.connect()
+		if err != nil {
+			return nil, err
+		}
+		nameVer, err := randomGen.GetAppNameVersion()
+		if err != nil {
+			randomGen.Close()
+			return nil, fmt.Errorf("GetAppNameVersion failed: %w", err)
+		}
+		return tkeyReader{randomGen, nameVer.Version}, nil
+	})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if viaDaemon {
+		le.Printf("Fetching random data from daemon on %s\n", opts.socket)
+	}
+
+	handleSignals(func() { removePendingOutputs(); os.Exit(1) }, os.Interrupt, syscall.SIGTERM)
+
+	if _, err := genRandomData(context.Background(), src, opts.genBytes, opts.filePath, opts.force, opts.verbose); err != nil {
+		return fmt.Errorf("genRandomData failed: %w", err)
+	}
+
+	return nil
+}
+
+// applyReseedPolicy sets the reseed interval and reseeds the DRBG
+// on the TKey as asked for and returns the policy in effect. An
+// interval of 0 keeps the current interval.
+func applyReseedPolicy(randomGen RandomGen, appVersion uint32, reseedEvery uint32, reseedBefore bool) (*reseedPolicy, error) {
+	if appVersion < appVersionExtended {
+		if reseedEvery != 0 || reseedBefore {
+			return nil, errAppTooOld("reseeding", appVersionExtended, appVersion)
+		}
+
+		// Older device apps always use the default
+		return &reseedPolicy{Interval: MaxReseedInterval}, nil
+	}
+
+	if reseedEvery != 0 {
+		if err := randomGen.SetReseedInterval(reseedEvery); err != nil {
+			return nil, fmt.Errorf("SetReseedInterval failed: %w", err)
+		}
+	}
+
+	if reseedBefore {
+		if err := randomGen.Reseed(); err != nil {
+			return nil, fmt.Errorf("Reseed failed: %w", err)
+		}
+	}
+
+	status, err := randomGen.Status()
+	if err != nil {
+		return nil, fmt.Errorf("Status failed: %w", err)
+	}
+
+	return &reseedPolicy{Interval: status.ReseedInterval, Before: reseedBefore}, nil
+}
+
 func handleSignals(action func(), sig ...os.Signal) {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, sig...)
@@ -353,17 +907,37 @@ func handleSignals(action func(), sig ...os.Signal) {
 	}()
 }
 
-func isFirmwareMode(tk *tkeyclient.TillitisKey) bool {
+// interruptOnce returns a signal handler cancelling ctx with
+// interrupt the first time, so that generate stops and signs what it
+// has, and calling quit the next.
+func interruptOnce(ctx context.Context, interrupt func(), quit func()) func() {
+	return func() {
+		if ctx.Err() != nil {
+			quit()
+			return
+		}
+
+		le.Printf("\nInterrupted, signing what was generated so far. Interrupt again to quit at once.\n")
+		interrupt()
+	}
+}
+
+// firmwareNameVersion returns the name and version of the firmware,
+// or nil if the TKey isn't in firmware mode.
+func firmwareNameVersion(tk *tkeyclient.TillitisKey) *tkeyclient.NameVersion {
 	nameVer, err := tk.GetNameVersion()
 	if err != nil {
 		if !errors.Is(err, io.EOF) && !errors.Is(err, tkeyclient.ErrResponseStatusNotOK) {
 			le.Printf("GetNameVersion failed: %s\n", err)
 		}
-		return false
+		return nil
 	}
-	// not caring about nameVer.Version
-	return nameVer.Name0 == wantFWName0 &&
-		nameVer.Name1 == wantFWName1
+	// Any version of the firmware
+	if nameVer.Name0 != wantFWName0 || nameVer.Name1 != wantFWName1 {
+		return nil
+	}
+
+	return nameVer
 }
 
 func isWantedApp(randomGen RandomGen) bool {
@@ -379,38 +953,41 @@ func isWantedApp(randomGen RandomGen) bool {
 		nameVer.Name1 == wantAppName1
 }
 
-func loadApp(tk *tkeyclient.TillitisKey, enterUSS bool, fileUSS string) error {
-	if isFirmwareMode(tk) {
+// loadApp loads the device app in app, unless the TKey is already running an
+// app, which is an error if required is true. It returns the name and version of
+// the firmware it was loaded by, or nil if it was already running.
+func loadApp(tk *tkeyclient.TillitisKey, app []byte, required bool, uss func() ([]byte, error)) (*tkeyclient.NameVersion, error) {
+	firmware := firmwareNameVersion(tk)
+	if firmware != nil {
 		var secret []byte
 		var err error
 
-		if enterUSS {
-			secret, err = tkeyutil.InputUSS()
-			if err != nil {
-				return fmt.Errorf("InputUSS: %w", err)
-			}
-		}
-		if fileUSS != "" {
-			secret, err = tkeyutil.ReadUSS(fileUSS)
+		if uss != nil {
+			secret, err = uss()
 			if err != nil {
-				return fmt.Errorf("ReadUSS: %w", err)
+				return nil, err
 			}
 		}
 
-		if err := tk.LoadApp(appBinary, secret); err != nil {
-			return fmt.Errorf("LoadApp failed: %w", err)
+		if err := tk.LoadApp(app, secret); err != nil {
+			return nil, fmt.Errorf("LoadApp failed: %w", err)
 		}
-	} else if enterUSS || fileUSS != "" {
+	} else if required {
+		return nil, fmt.Errorf("TKey already running an app, replug it to load --app")
+	} else if uss != nil {
 		le.Printf("Warning: App already loaded. Use of USS not possible. Continuing with already loaded app...\n")
 	}
 
-	return nil
+	return firmware, nil
 }
 
-// genRandomData fetches genBytes bytes of random data and either prints to a file or stdout
-func genRandomData(randomGen RandomGen, genBytes int, filePath string, verbose bool) ([]byte, error) {
+// genRandomData fetches genBytes bytes of random data from src and either prints to a file or stdout.
+// The file is only in place when complete and is overwritten only if force is true.
+// If ctx is done it stops fetching, outputs what src has buffered already, and returns what was
+// output with errInterrupted.
+func genRandomData(ctx context.Context, src io.Reader, genBytes int, filePath string, force bool, verbose bool) ([]byte, error) {
 	var totRandom []byte
-	var file *os.File
+	var file *outputFile
 	var fileErr error
 	var toFile bool
 
@@ -420,10 +997,11 @@ func genRandomData(randomGen RandomGen, genBytes int, filePath string, verbose b
 
 	if filePath != "" {
 		toFile = true
-		file, fileErr = os.Create(filePath)
+		file, fileErr = createOutput(filePath, force)
 		if fileErr != nil {
-			return nil, fmt.Errorf("could not create file %s: %w", filePath, fileErr)
+			return nil, fileErr
 		}
+		defer file.Abort()
 	}
 
 	if !toFile {
@@ -441,14 +1019,28 @@ func genRandomData(randomGen RandomGen, genBytes int, filePath string, verbose b
 	if progressIncrements < 256 {
 		progressIncrements = 256
 	}
+	interrupted := false
 	for {
 		get := left
 		if get > RandomPayloadMaxBytes {
 			get = RandomPayloadMaxBytes
 		}
-		random, err := randomGen.GetRandom(get)
-		if err != nil {
-			return nil, fmt.Errorf("GetRandom failed: %w", err)
+
+		if ctx.Err() != nil {
+			buffered := 0
+			if b, ok := src.(interface{ Buffered() int }); ok {
+				buffered = b.Buffered()
+			}
+			if buffered == 0 {
+				interrupted = true
+				break
+			}
+			get = min(get, buffered)
+		}
+
+		random := make([]byte, get)
+		if _, err := io.ReadFull(src, random); err != nil {
+			return nil, fmt.Errorf("could not read random data: %w", err)
 		}
 		totRandom = append(totRandom, random...)
 
@@ -485,87 +1077,56 @@ func genRandomData(randomGen RandomGen, genBytes int, filePath string, verbose b
 		fmt.Printf("\n\n")
 	}
 
-	file.Close()
-
-	return totRandom, nil
-}
-
-// fileInputToHex reads inputFile and returns a trimmed slice decoded to hex.
-func fileInputToHex(inputFile string) ([]byte, error) {
-	input, err := os.ReadFile(inputFile)
-	if err != nil {
-		return nil, fmt.Errorf("could not read %s: %w", inputFile, err)
+	if toFile {
+		if err := file.Commit(); err != nil {
+			return nil, err
+		}
 	}
 
-	input = bytes.Trim(input, "\n")
-	hexOutput := make([]byte, hex.DecodedLen(len(input)))
-	_, err = hex.Decode(hexOutput, input)
-	if err != nil {
-		return nil, fmt.Errorf("could not decode: %w", err)
+	if interrupted {
+		return totRandom, errInterrupted
 	}
-	return hexOutput, nil
+
+	return totRandom, nil
 }
 
-// verifySignature verifies a Ed25519 signature from input files of message, signature and public key
-func verifySignature(fileRandData string, fileSignature string, filePubkey string, isBinary bool) error {
-	signature, err := fileInputToHex(fileSignature)
+// verifySignature verifies a Ed25519 signature from inputs of message,
+// signature and public key, each a file, '-' for stdin or an inline
+// value, see readInput.
+func verifySignature(fileRandData string, fileSignature string, filePubkey string, isBinary bool, nonce []byte, context string) error {
+	signature, err := readEncodedInput("signature", fileSignature, ed25519.SignatureSize)
 	if err != nil {
-		return fmt.Errorf("fileInputToHex failed: %w", err)
-	}
-
-	if len(signature) != 64 {
-		return fmt.Errorf("invalid length of signature. Expected 64 bytes, got %d bytes", len(signature))
+		return err
 	}
 
-	pubkey, err := fileInputToHex(filePubkey)
+	pubkey, err := readPubkey(filePubkey)
 	if err != nil {
-		return fmt.Errorf("fileInputToHex failed: %w", err)
-	}
-
-	if len(pubkey) != 32 {
-		return fmt.Errorf("invalid length of public key. Expected 32 bytes, got %d bytes", len(pubkey))
+		return err
 	}
 
 	fmt.Printf("Public key: %x\n", pubkey)
 	fmt.Printf("Signature: %x\n", signature)
 
-	var message []byte
+	// Binary data is read as a stream, so it can be of any size
+	var message io.ReadCloser
 	if isBinary {
-
-		message, err = os.ReadFile(fileRandData)
-		if err != nil {
-			return fmt.Errorf("could not read %s: %w", fileRandData, err)
-		}
+		message, err = openBinaryInput(fileRandData)
 	} else {
-		message, err = fileInputToHex(fileRandData)
-		if err != nil {
-			return fmt.Errorf("fileInputToHex failed: %w", err)
-		}
+		var data []byte
+		data, err = readEncodedInput("random data", fileRandData, 0)
+		message = io.NopCloser(bytes.NewReader(data))
 	}
-
-	digest := doHash(message)
-	le.Printf("BLAKE2s hash: %x\n", digest)
-
-	if !ed25519.Verify(pubkey, digest[:], signature) {
-		return fmt.Errorf("signature not valid")
+	if err != nil {
+		return err
 	}
+	defer message.Close()
 
-	return nil
-}
-
-// verifyHash returns error if the hash and raw data hashed is not equal
-func verifyHash(hash []byte, randomData []byte) error {
-	localHash := doHash(randomData)
-
-	if !bytes.Equal(hash, localHash[:]) {
-		return fmt.Errorf("hash not equal")
+	digest, err := randverify.Verify(pubkey, signature, message, nonce, context)
+	if digest != nil {
+		le.Printf("BLAKE2s hash: %x\n", digest)
 	}
-	return nil
-}
 
-// doHash returns a blake2s hash of input
-func doHash(data []byte) [32]byte {
-	return blake2s.Sum256(data)
+	return err
 }
 
 func readBuildInfo() string {
@@ -591,6 +1152,5 @@ func GetEmbeddedAppName() string {
 // GetEmbeddedAppDigest returns a string of the SHA512 digest for the embedded
 // device app
 func GetEmbeddedAppDigest() string {
-	digest := sha512.Sum512(appBinary)
-	return hex.EncodeToString(digest[:])
+	return appDigest(appBinary)
 }
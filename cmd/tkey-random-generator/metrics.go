@@ -0,0 +1,236 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// metrics are the counters of this process, exported in the
+// Prometheus text format.
+var metrics = newClientMetrics()
+
+type clientMetrics struct {
+	randomBytes    counter
+	frames         counter
+	frameErrors    counter
+	frameDuration  *histogram
+	signatures     counter
+	healthFailures counter
+	reconnects     counter
+	connected      gauge
+}
+
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		// A frame of random data takes around 20 ms at the
+		// default speed
+		frameDuration: newHistogram([]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}),
+	}
+}
+
+type counter struct {
+	v atomic.Uint64
+}
+
+func (c *counter) Add(n int) {
+	c.v.Add(uint64(n))
+}
+
+func (c *counter) Inc() {
+	c.v.Add(1)
+}
+
+type gauge struct {
+	v atomic.Int64
+}
+
+func (g *gauge) Set(v int64) {
+	g.v.Store(v)
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// observeFrame records a GET_RANDOM frame of n bytes that took d and
+// failed with err, if not nil.
+func (m *clientMetrics) observeFrame(n int, d time.Duration, err error) {
+	m.frames.Inc()
+	m.frameDuration.Observe(d.Seconds())
+
+	if err != nil {
+		m.frameErrors.Inc()
+		return
+	}
+
+	m.randomBytes.Add(n)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (m *clientMetrics) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+
+	writeMetric(bw, "tkey_random_bytes_total", "counter",
+		"Bytes of random data read from the TKey.", float64(m.randomBytes.v.Load()))
+	writeMetric(bw, "tkey_random_frames_total", "counter",
+		"Frames of random data requested from the TKey.", float64(m.frames.v.Load()))
+	writeMetric(bw, "tkey_random_frame_errors_total", "counter",
+		"Frames of random data that failed.", float64(m.frameErrors.v.Load()))
+
+	m.frameDuration.mu.Lock()
+	fmt.Fprintf(bw, "# HELP tkey_random_frame_duration_seconds Time to get a frame of random data from the TKey.\n")
+	fmt.Fprintf(bw, "# TYPE tkey_random_frame_duration_seconds histogram\n")
+	for i, bound := range m.frameDuration.bounds {
+		fmt.Fprintf(bw, "tkey_random_frame_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), m.frameDuration.counts[i])
+	}
+	fmt.Fprintf(bw, "tkey_random_frame_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.frameDuration.count)
+	fmt.Fprintf(bw, "tkey_random_frame_duration_seconds_sum %s\n",
+		strconv.FormatFloat(m.frameDuration.sum, 'g', -1, 64))
+	fmt.Fprintf(bw, "tkey_random_frame_duration_seconds_count %d\n", m.frameDuration.count)
+	m.frameDuration.mu.Unlock()
+
+	writeMetric(bw, "tkey_random_signature_sessions_total", "counter",
+		"Signature sessions signed by the TKey.", float64(m.signatures.v.Load()))
+	writeMetric(bw, "tkey_random_health_test_failures_total", "counter",
+		"Blocks of random data failing a health test.", float64(m.healthFailures.v.Load()))
+	writeMetric(bw, "tkey_random_reconnects_total", "counter",
+		"Times the TKey was reconnected.", float64(m.reconnects.v.Load()))
+	writeMetric(bw, "tkey_random_device_connected", "gauge",
+		"1 if connected to the TKey, otherwise 0.", float64(m.connected.v.Load()))
+
+	n := int64(bw.Buffered())
+	if err := bw.Flush(); err != nil {
+		return 0, fmt.Errorf("could not write metrics: %w", err)
+	}
+
+	return n, nil
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+		name, help, name, kind, name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// registerMetricsFlag adds the flag enabling the metrics endpoint to
+// fs.
+func registerMetricsFlag(fs *pflag.FlagSet, addr *string) {
+	fs.StringVar(addr, "metrics-listen", "",
+		"Serve Prometheus metrics on http://`ADDRESS`/metrics, with ADDRESS as host:port.")
+}
+
+// serveMetrics serves the metrics over HTTP on addr until ctx is done.
+// It does nothing if addr is empty.
+func serveMetrics(ctx context.Context, addr string) error {
+	if addr == "" {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = metrics.WriteTo(w)
+	})
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			le.Printf("Error serving metrics: %v\n", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	le.Printf("Serving metrics on http://%s/metrics\n", addr)
+
+	return nil
+}
+
+// writeMetricsFile writes the metrics, and whether the run succeeded,
+// to path for the textfile collector of the Prometheus node exporter.
+// The file is replaced atomically so it's never read half written.
+func writeMetricsFile(path string, success bool) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var ok float64
+	if success {
+		ok = 1
+	}
+
+	_, err = metrics.WriteTo(tmp)
+	if err == nil {
+		writeMetric(tmp, "tkey_random_last_run_success", "gauge",
+			"1 if the last run succeeded, otherwise 0.", ok)
+		writeMetric(tmp, "tkey_random_last_run_timestamp_seconds", "gauge",
+			"Unix time the last run ended.", float64(time.Now().Unix()))
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("could not write metrics: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not write metrics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+// mixDomain separates the mixing from other uses of BLAKE2s.
+const mixDomain = "tkey-random-generator mix v1"
+
+// mixExtractor names the extractor in the JSON output.
+const mixExtractor = "blake2s-keyed-v1"
+
+// mixSources are the sources mixed with the TKey output.
+type mixSources struct {
+	os   bool
+	file string
+}
+
+// parseMixSources parses the values of --mix: "os" and "file:PATH".
+func parseMixSources(values []string) (mixSources, error) {
+	var src mixSources
+
+	for _, v := range values {
+		switch {
+		case v == "os":
+			src.os = true
+		case strings.HasPrefix(v, "file:") && len(v) > len("file:"):
+			if src.file != "" {
+				return src, fmt.Errorf("--mix takes only one file")
+			}
+			src.file = strings.TrimPrefix(v, "file:")
+		default:
+			return src, fmt.Errorf("unknown --mix source %q, expected os or file:PATH", v)
+		}
+	}
+
+	return src, nil
+}
+
+// names returns the names of all sources, starting with the TKey.
+func (s mixSources) names() []string {
+	names := []string{"tkey"}
+	if s.os {
+		names = append(names, "os")
+	}
+	if s.file != "" {
+		names = append(names, "file")
+	}
+
+	return names
+}
+
+// mixReader mixes n bytes of TKey output with the other sources. The
+// output is made in blocks of 32 bytes, the last one possibly shorter.
+// Block i is the first bytes of
+//
+//	BLAKE2s-256(key=K, i as 64 bit big endian || T_i || O_i)
+//
+// where T_i and O_i are block i of the TKey output and of crypto/rand,
+// with the same length as the output block, and O_i is empty unless
+// mixing with the OS. K is BLAKE2s-256(mixDomain || F) where F is the
+// contents of the file of extra entropy, or empty.
+//
+// The output is thus unpredictable if either the TKey or the OS output
+// is, or the file is secret. The TKey output is kept in raw, since
+// that's what the TKey signs.
+type mixReader struct {
+	tkey      io.Reader
+	os        io.Reader
+	key       [blake2s.Size]byte
+	remaining int
+	block     uint64
+	buf       []byte
+	raw       []byte
+}
+
+func newMixReader(tkey io.Reader, src mixSources, n int) (*mixReader, error) {
+	m := &mixReader{
+		tkey:      tkey,
+		remaining: n,
+		raw:       make([]byte, 0, n),
+	}
+
+	if src.os {
+		m.os = rand.Reader
+	}
+
+	var extra []byte
+	if src.file != "" {
+		var err error
+		extra, err = os.ReadFile(src.file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read entropy file: %w", err)
+		}
+		if len(extra) == 0 {
+			return nil, fmt.Errorf("entropy file %s is empty", src.file)
+		}
+	}
+
+	m.key = blake2s.Sum256(append([]byte(mixDomain), extra...))
+	clear(extra)
+
+	return m, nil
+}
+
+// Read fills p with mixed random data. It implements io.Reader.
+func (m *mixReader) Read(p []byte) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		if len(m.buf) == 0 {
+			if m.remaining == 0 {
+				if n == 0 {
+					return 0, io.EOF
+				}
+				break
+			}
+
+			if err := m.next(); err != nil {
+				return n, err
+			}
+		}
+
+		k := copy(p[n:], m.buf)
+		m.buf = m.buf[k:]
+		n += k
+	}
+
+	return n, nil
+}
+
+// next makes the next block of output.
+func (m *mixReader) next() error {
+	size := min(m.remaining, blake2s.Size)
+
+	h, err := blake2s.New256(m.key[:])
+	if err != nil {
+		return fmt.Errorf("blake2s.New256: %w", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], m.block)
+	h.Write(counter[:])
+
+	block := make([]byte, size)
+	if _, err := io.ReadFull(m.tkey, block); err != nil {
+		return fmt.Errorf("could not read random data: %w", err)
+	}
+	m.raw = append(m.raw, block...)
+	h.Write(block)
+
+	if m.os != nil {
+		if _, err := io.ReadFull(m.os, block); err != nil {
+			return fmt.Errorf("could not read OS random data: %w", err)
+		}
+		h.Write(block)
+	}
+
+	m.buf = h.Sum(nil)[:size]
+	m.block++
+	m.remaining -= size
+
+	return nil
+}
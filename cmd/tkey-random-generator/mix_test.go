@@ -0,0 +1,146 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseMixSources(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		values []string
+		want   mixSources
+		ok     bool
+	}{
+		{nil, mixSources{}, true},
+		{[]string{"os"}, mixSources{os: true}, true},
+		{[]string{"file:/etc/seed"}, mixSources{file: "/etc/seed"}, true},
+		{[]string{"os", "file:seed"}, mixSources{os: true, file: "seed"}, true},
+		{[]string{"file:a", "file:b"}, mixSources{}, false},
+		{[]string{"file:"}, mixSources{}, false},
+		{[]string{"urandom"}, mixSources{}, false},
+	} {
+		got, err := parseMixSources(tc.values)
+		if (err == nil) != tc.ok || (tc.ok && got != tc.want) {
+			t.Errorf("%q: got %+v, %v", tc.values, got, err)
+		}
+	}
+
+	want := []string{"tkey", "os", "file"}
+	if got := (mixSources{os: true, file: "seed"}).names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("names: got %q, want %q", got, want)
+	}
+}
+
+func TestMixReader(t *testing.T) {
+	t.Parallel()
+
+	tkey := make([]byte, 70)
+	for i := range tkey {
+		tkey[i] = byte(i)
+	}
+
+	seed := filepath.Join(t.TempDir(), "seed")
+	if err := os.WriteFile(seed, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Computed with Python's hashlib.blake2s
+	for _, tc := range []struct {
+		src  mixSources
+		want string
+	}{
+		{
+			mixSources{},
+			"8355e973833338b99205403085335e5942747baf033a9e91880d7f00d9dfb299" +
+				"38e5fbb7e2b1b1bbb08dc09ced789c503f69ec513bbfef0ce21d6eea6ae5599c" +
+				"c4523e6b90ce",
+		},
+		{
+			mixSources{file: seed},
+			"bb9af3c4c41698dc83dcc74c2fb0f80ff8cc52beb4001c197692328cf994a909" +
+				"442bf1d927e54a4352436887c2b75aa53e488839dfeed820417ea2bbac357b30" +
+				"f0bf8b63bae0",
+		},
+	} {
+		m, err := newMixReader(bytes.NewReader(tkey), tc.src, len(tkey))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Read in odd sizes, across the blocks
+		got, err := io.ReadAll(iotest.OneByteReader(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf("%+v: got %x, want %s", tc.src, got, tc.want)
+		}
+		if !bytes.Equal(m.raw, tkey) {
+			t.Errorf("%+v: raw TKey output not kept", tc.src)
+		}
+	}
+}
+
+func TestMixReaderOS(t *testing.T) {
+	t.Parallel()
+
+	tkey := make([]byte, 100)
+
+	m, err := newMixReader(bytes.NewReader(tkey), mixSources{os: true}, len(tkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := io.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(tkey) || !bytes.Equal(m.raw, tkey) {
+		t.Errorf("got %d bytes, raw %x", len(got), m.raw)
+	}
+
+	// The same TKey output gives other output when mixed with the OS
+	again, err := newMixReader(bytes.NewReader(tkey), mixSources{os: true}, len(tkey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := io.ReadAll(again); bytes.Equal(got, other) {
+		t.Errorf("same output twice when mixing with the OS")
+	}
+}
+
+func TestMixReaderErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{empty, filepath.Join(dir, "missing")} {
+		if _, err := newMixReader(bytes.NewReader(nil), mixSources{file: file}, 32); err == nil {
+			t.Errorf("%s: want an error", file)
+		}
+	}
+
+	// The TKey stops short
+	m, err := newMixReader(bytes.NewReader(make([]byte, 40)), mixSources{}, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(m); err == nil {
+		t.Errorf("short TKey output, want an error")
+	}
+}
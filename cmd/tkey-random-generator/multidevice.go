@@ -0,0 +1,599 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"tkey-random-generator/randverify"
+)
+
+// Modes of using several TKeys at once.
+const (
+	// multiCombine XORs the output of all TKeys, so the result is
+	// unpredictable as long as one of them is.
+	multiCombine = "combine"
+	// multiStripe reads different parts of the output from the
+	// TKeys in parallel.
+	multiStripe = "stripe"
+)
+
+// stripeBlock is the number of bytes a TKey reads at a time when
+// striping.
+const stripeBlock = 4096
+
+// multiReadSize is how much generate asks several TKeys for at a
+// time, so that they have something to do in parallel.
+const multiReadSize = 16 * stripeBlock
+
+// deviceSet reads random data from one or more TKeys. With several,
+// the output of every TKey is health tested on its own, so that a
+// failing TKey isn't hidden by the others.
+type deviceSet struct {
+	mode    string
+	ports   []string
+	devices []tkeyDevice
+	health  []healthTester
+
+	// keep is true to record the ranges of the output every TKey
+	// returned
+	keep   bool
+	ranges [][][2]int64
+	offset int64
+
+	// sessions hash what every TKey returned since the signature
+	// session started, like the TKey does, and counts the bytes, when
+	// signing the sessions for the audit log
+	sessions []hash.Hash
+	counts   []int64
+}
+
+func newDeviceSet(mode string, ports []string, devices []tkeyDevice) *deviceSet {
+	return &deviceSet{
+		mode:    mode,
+		ports:   ports,
+		devices: devices,
+		health:  make([]healthTester, len(devices)),
+		ranges:  make([][][2]int64, len(devices)),
+	}
+}
+
+// superviseAll connects to the TKeys described by the flags and keeps
+// them connected until ctx is done, like supervise.
+func (f *deviceFlags) superviseAll(ctx context.Context) (*deviceSet, error) {
+	if !f.several() {
+		device, err := f.supervise(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return newDeviceSet("", []string{f.port()}, []tkeyDevice{device}), nil
+	}
+
+	paths, err := f.paths()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the USS and the app once for all of them
+	if f.hasUSS() {
+		if _, err := f.uss(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := f.app(); err != nil {
+		return nil, err
+	}
+
+	var devices []tkeyDevice
+	var pubkeys [][]byte
+	for _, path := range paths {
+		device, err := f.forPort(path).supervise(ctx)
+		if err == nil {
+			pubkeys = append(pubkeys, device.pubkey)
+			devices = append(devices, device)
+			err = checkDistinct(paths, pubkeys)
+		}
+		if err != nil {
+			closeDevices(devices)
+			return nil, fmt.Errorf("TKey on %s: %w", path, err)
+		}
+	}
+
+	le.Printf("Using %d TKeys in %s mode\n", len(devices), f.multi)
+
+	return newDeviceSet(f.multi, paths, devices), nil
+}
+
+// checkDistinct returns an error if the last of pubkeys, of the TKeys
+// on paths, is the same as one before it, which means the same TKey
+// was given twice.
+func checkDistinct(paths []string, pubkeys [][]byte) error {
+	last := len(pubkeys) - 1
+
+	for i := range last {
+		if bytes.Equal(pubkeys[i], pubkeys[last]) {
+			return fmt.Errorf("same public key as the TKey on %s", paths[i])
+		}
+	}
+
+	return nil
+}
+
+func closeDevices(devices []tkeyDevice) {
+	for _, device := range devices {
+		device.Close()
+	}
+}
+
+// Read fills p with random data. It implements io.Reader.
+func (s *deviceSet) Read(p []byte) (int, error) {
+	var err error
+
+	switch s.mode {
+	case multiCombine:
+		err = s.combine(p)
+	case multiStripe:
+		err = s.stripe(p)
+	default:
+		n, err := s.devices[0].Read(p)
+		s.track(0, p[:n])
+		return n, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.offset += int64(len(p))
+
+	return len(p), nil
+}
+
+// combine fills p with the XOR of the output of all TKeys.
+func (s *deviceSet) combine(p []byte) error {
+	bufs := make([][]byte, len(s.devices))
+
+	err := s.each(func(i int) error {
+		bufs[i] = make([]byte, len(p))
+		return s.read(i, bufs[i])
+	})
+	if err != nil {
+		return err
+	}
+
+	clear(p)
+	for i, buf := range bufs {
+		subtle.XORBytes(p, p, buf)
+
+		if s.keep {
+			s.addRange(i, s.offset, len(p))
+		}
+		clear(buf)
+	}
+
+	return nil
+}
+
+// stripe fills p with blocks read from all TKeys in parallel, every
+// TKey taking the next block when done with one.
+func (s *deviceSet) stripe(p []byte) error {
+	var mu sync.Mutex
+	next := 0
+
+	return s.each(func(i int) error {
+		for {
+			mu.Lock()
+			start := next
+			next = min(next+stripeBlock, len(p))
+			end := next
+			mu.Unlock()
+
+			if start == end {
+				return nil
+			}
+
+			if err := s.read(i, p[start:end]); err != nil {
+				return err
+			}
+
+			if s.keep {
+				s.addRange(i, s.offset+int64(start), end-start)
+			}
+		}
+	})
+}
+
+// each runs fn for every TKey in parallel and returns the errors.
+func (s *deviceSet) each(fn func(i int) error) error {
+	errs := make([]error, len(s.devices))
+
+	var wg sync.WaitGroup
+	for i := range s.devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// read fills p with random data from TKey i and health tests it.
+func (s *deviceSet) read(i int, p []byte) error {
+	if _, err := io.ReadFull(s.devices[i], p); err != nil {
+		return s.wrap(i, fmt.Errorf("could not read random data: %w", err))
+	}
+	s.track(i, p)
+
+	if err := s.health[i].Check(p); err != nil {
+		return s.wrap(i, err)
+	}
+
+	return nil
+}
+
+// trackSessions starts hashing what every TKey returns, keyed with
+// nonce and bound to label like the sessions on the TKeys, so that the
+// signature sessions can be checked and logged by auditSessions.
+func (s *deviceSet) trackSessions(nonce []byte, label string) error {
+	s.sessions = make([]hash.Hash, len(s.devices))
+	s.counts = make([]int64, len(s.devices))
+
+	for i := range s.devices {
+		h, err := randverify.NewHash(nonce, label)
+		if err != nil {
+			return err
+		}
+		s.sessions[i] = h
+	}
+
+	return nil
+}
+
+// track adds p, returned by TKey i, to its signature session, if
+// tracked.
+func (s *deviceSet) track(i int, p []byte) {
+	if s.sessions == nil {
+		return
+	}
+
+	s.sessions[i].Write(p)
+	s.counts[i] += int64(len(p))
+}
+
+// addRange records that TKey i returned n bytes at offset in the
+// output, extending the last range if they follow each other.
+func (s *deviceSet) addRange(i int, offset int64, n int) {
+	ranges := s.ranges[i]
+
+	if last := len(ranges) - 1; last >= 0 && ranges[last][0]+ranges[last][1] == offset {
+		ranges[last][1] += int64(n)
+		return
+	}
+
+	s.ranges[i] = append(ranges, [2]int64{offset, int64(n)})
+}
+
+// wrap adds which TKey failed to err, if there are several.
+func (s *deviceSet) wrap(i int, err error) error {
+	if len(s.devices) == 1 {
+		return err
+	}
+
+	return fmt.Errorf("TKey on %s: %w", s.ports[i], err)
+}
+
+// endSessions re-inits the hash on all TKeys.
+func (s *deviceSet) endSessions() {
+	for i, device := range s.devices {
+		nameVer, err := device.GetAppNameVersion()
+		if err != nil {
+			le.Printf("GetAppNameVersion failed: %v\n", s.wrap(i, err))
+			continue
+		}
+
+		if err := endSession(device, nameVer.Version); err != nil && !errors.Is(err, errReconnected) {
+			le.Printf("%v\n", s.wrap(i, err))
+		}
+	}
+}
+
+// auditSessions ends the signature sessions on all TKeys, tracked
+// since trackSessions, and appends their signatures to the audit log
+// in path. A TKey that was reconnected during its session can't sign
+// what it returned before that, so the session is left out.
+func (s *deviceSet) auditSessions(path string, command string) error {
+	var entries []*auditEntry
+	var errs []error
+
+	for i, device := range s.devices {
+		signature, digest, err := device.GetSignature()
+		if errors.Is(err, errReconnected) {
+			le.Printf("Warning: %v\n", s.wrap(i, fmt.Errorf("%w, what it returned is not in the audit log", err)))
+			continue
+		}
+		if errors.Is(err, ErrNoSignature) && s.counts[i] == 0 {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, s.wrap(i, fmt.Errorf("GetSig failed: %w", err)))
+			continue
+		}
+
+		if s.counts[i] == 0 {
+			continue
+		}
+
+		entry, err := s.auditEntry(i, command, signature, digest)
+		if err != nil {
+			errs = append(errs, s.wrap(i, err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := appendAudit(path, entries...); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// auditEntry checks the signature and hash TKey i made of its session
+// and returns the audit log entry of it.
+func (s *deviceSet) auditEntry(i int, command string, signature []byte, digest []byte) (*auditEntry, error) {
+	if !bytes.Equal(digest, s.sessions[i].Sum(nil)) {
+		return nil, fmt.Errorf("hash FAILED verification: hash not equal")
+	}
+
+	pubkey, err := s.devices[i].GetPubkey()
+	if err != nil {
+		return nil, fmt.Errorf("GetPubkey failed: %w", err)
+	}
+
+	if !ed25519.Verify(pubkey, digest, signature) {
+		return nil, fmt.Errorf("signature FAILED verification")
+	}
+
+	nameVer, err := s.devices[i].GetAppNameVersion()
+	if err != nil {
+		return nil, fmt.Errorf("GetAppNameVersion failed: %w", err)
+	}
+
+	return &auditEntry{
+		Command:   command,
+		Bytes:     s.counts[i],
+		Hash:      digest,
+		Signature: signature,
+		Pubkey:    pubkey,
+		Scheme:    randverify.SchemeLegacy,
+		App:       appOf(nameVer, s.devices[i].AppDigest()),
+		Firmware:  firmwareApp(s.devices[i].Firmware()),
+	}, nil
+}
+
+// Close closes the connections to all TKeys.
+func (s *deviceSet) Close() error {
+	var errs []error
+	for i, device := range s.devices {
+		if err := device.Close(); err != nil {
+			errs = append(errs, s.wrap(i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// generateSeveral is generate using several TKeys at once. Every TKey
+// signs what it returned, which is recorded in the JSON summary.
+func generateSeveral(opts generateOptions) error {
+	paths, err := opts.dev.paths()
+	if err != nil {
+		return err
+	}
+
+	if opts.dev.hasUSS() {
+		if _, err := opts.dev.uss(); err != nil {
+			return err
+		}
+	}
+	if _, err := opts.dev.app(); err != nil {
+		return err
+	}
+
+	var randomGens []RandomGen
+	var devices []tkeyDevice
+	closeAll := func() {
+		closeDevices(devices)
+	}
+
+	for _, path := range paths {
+		randomGen, err := opts.dev.forPort(path).connect()
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("TKey on %s: %w", path, err)
+		}
+		randomGens = append(randomGens, randomGen)
+		devices = append(devices, randomGen)
+	}
+
+	ctx, interrupt := context.WithCancel(context.Background())
+	defer interrupt()
+	handleSignals(interruptOnce(ctx, interrupt, func() { removePendingOutputs(); closeAll(); os.Exit(1) }), os.Interrupt, syscall.SIGTERM)
+	defer closeAll()
+
+	infos := make([]deviceInfo, len(randomGens))
+	var pubkeys [][]byte
+	for i, randomGen := range randomGens {
+		nameVer, err := randomGen.GetAppNameVersion()
+		if err != nil {
+			return fmt.Errorf("TKey on %s: GetAppNameVersion failed: %w", paths[i], err)
+		}
+
+		pubkey, err := randomGen.GetPubkey()
+		if err != nil {
+			return fmt.Errorf("TKey on %s: GetPubkey failed: %w", paths[i], err)
+		}
+		pubkeys = append(pubkeys, pubkey)
+		if err := checkDistinct(paths, pubkeys); err != nil {
+			return fmt.Errorf("TKey on %s: %w", paths[i], err)
+		}
+
+		policy, err := applyReseedPolicy(randomGen, nameVer.Version, opts.reseedEvery, opts.reseedBefore)
+		if err != nil {
+			return fmt.Errorf("TKey on %s: %w", paths[i], err)
+		}
+
+		if opts.nonce != nil {
+			if err := setNonce(randomGen, nameVer.Version, opts.nonce); err != nil {
+				return fmt.Errorf("TKey on %s: %w", paths[i], err)
+			}
+		}
+
+		if opts.context != "" {
+			if err := setContext(randomGen, nameVer.Version, opts.context); err != nil {
+				return fmt.Errorf("TKey on %s: %w", paths[i], err)
+			}
+		}
+
+		infos[i] = deviceInfo{
+			Port:   paths[i],
+			App:    appOf(nameVer, randomGen.AppDigest()),
+			Reseed: policy,
+			Pubkey: hex.EncodeToString(pubkey),
+		}
+	}
+
+	set := newDeviceSet(opts.dev.multi, paths, devices)
+	set.keep = true
+	// What every TKey signed is hashed as it's read, since when
+	// combining it's not in the output, and must not be kept
+	if err := set.trackSessions(opts.nonce, opts.context); err != nil {
+		return err
+	}
+	le.Printf("Using %d TKeys in %s mode\n", len(devices), opts.dev.multi)
+
+	src := bufio.NewReaderSize(io.LimitReader(set, int64(opts.genBytes)), multiReadSize)
+	// Interrupted, what's already read from the TKeys is still output,
+	// since they signed it
+	totRandom, err := genRandomData(ctx, src, opts.genBytes, opts.filePath, opts.force, opts.verbose)
+	interrupted := errors.Is(err, errInterrupted)
+	if interrupted {
+		le.Printf("Interrupted after %d of %d bytes.\n", len(totRandom), opts.genBytes)
+		if len(totRandom) == 0 {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("genRandomData failed: %w", err)
+	}
+
+	var entries []*auditEntry
+	for i, randomGen := range randomGens {
+		infos[i].Ranges = set.ranges[i]
+
+		if !opts.shouldSign {
+			// Re-init the hash on the TKey
+			if err := endSession(randomGen, infos[i].App.Version); err != nil {
+				return fmt.Errorf("TKey on %s: %w", paths[i], err)
+			}
+			continue
+		}
+
+		signature, hash, err := randomGen.GetSignature()
+		// Striping little, a TKey may have returned nothing
+		if errors.Is(err, ErrNoSignature) && set.counts[i] == 0 {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("TKey on %s: GetSig failed: %w", paths[i], err)
+		}
+
+		fmt.Printf("TKey on %s\n", paths[i])
+		fmt.Printf("Public key: %x\n", pubkeys[i])
+		fmt.Printf("Signature: %x\n", signature)
+		fmt.Printf("Hash: %x\n", hash)
+
+		if !bytes.Equal(hash, set.sessions[i].Sum(nil)) {
+			return fmt.Errorf("TKey on %s: hash FAILED verification: %w", paths[i], randverify.ErrHash)
+		}
+
+		le.Print(("\nVerifying signature ... "))
+		if !ed25519.Verify(pubkeys[i], hash, signature) {
+			return fmt.Errorf("TKey on %s: signature FAILED verification", paths[i])
+		}
+		le.Printf("signature verified.\n\n")
+
+		infos[i].Hash = hex.EncodeToString(hash)
+		infos[i].Signature = hex.EncodeToString(signature)
+
+		entries = append(entries, &auditEntry{
+			Command:   "generate",
+			Bytes:     set.counts[i],
+			Hash:      hash,
+			Signature: signature,
+			Pubkey:    pubkeys[i],
+			Nonce:     opts.nonce,
+			Scheme:    randverify.Scheme(opts.context),
+			Context:   opts.context,
+			App:       infos[i].App,
+			Firmware:  firmwareApp(randomGen.Firmware()),
+		})
+	}
+
+	if opts.auditLog != "" {
+		if err := appendAudit(opts.auditLog, entries...); err != nil {
+			return err
+		}
+	}
+
+	if opts.jsonPath != "" {
+		b := bundle{
+			Bytes:  len(totRandom),
+			File:   opts.filePath,
+			App:    infos[0].App,
+			Reseed: infos[0].Reseed,
+			Multi: &multiInfo{
+				Mode:    opts.dev.multi,
+				Devices: infos,
+			},
+		}
+		if interrupted {
+			b.Interrupted = true
+			b.Requested = opts.genBytes
+		}
+		if opts.filePath == "" {
+			b.Data = hex.EncodeToString(totRandom)
+		}
+		if opts.nonce != nil {
+			b.Nonce = hex.EncodeToString(opts.nonce)
+		}
+		if opts.shouldSign {
+			b.Scheme = randverify.Scheme(opts.context)
+			b.Context = opts.context
+		}
+
+		if err := writeBundle(opts.jsonPath, b, opts.force); err != nil {
+			return err
+		}
+	}
+
+	if interrupted {
+		return errInterrupted
+	}
+
+	return nil
+}
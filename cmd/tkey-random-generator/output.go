@@ -0,0 +1,177 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pendingOutputs are the temporary files of outputs not yet renamed
+// into place, removed by removePendingOutputs when quitting early.
+var pendingOutputs = struct {
+	sync.Mutex
+	names map[string]struct{}
+}{names: map[string]struct{}{}}
+
+// outputFile is an output of random data. It's written to a temporary
+// file in the same directory, with mode 0600, and only moved into place
+// when complete, so it's never seen half written and nothing is left
+// behind if writing fails. A file it overwrites keeps its mode.
+type outputFile struct {
+	*os.File
+	path  string
+	force bool
+	done  bool
+}
+
+// checkOutput returns an error if path exists and force is false.
+func checkOutput(path string, force bool) error {
+	if force {
+		return nil
+	}
+
+	if _, err := os.Lstat(path); !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+
+	return nil
+}
+
+// createOutput creates the temporary file of the output path. It
+// fails if path exists, unless force is true.
+func createOutput(path string, force bool) (*outputFile, error) {
+	if err := checkOutput(path, force); err != nil {
+		return nil, err
+	}
+
+	// Mode 0600
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create file %s: %w", path, err)
+	}
+
+	pendingOutputs.Lock()
+	pendingOutputs.names[f.Name()] = struct{}{}
+	pendingOutputs.Unlock()
+
+	return &outputFile{File: f, path: path, force: force}, nil
+}
+
+// Commit syncs the output to disk and moves it into place.
+func (f *outputFile) Commit() error {
+	if f.done {
+		return nil
+	}
+
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if f.force {
+			err = f.replace()
+		} else {
+			err = f.link()
+		}
+	}
+	if err != nil {
+		f.remove()
+		return fmt.Errorf("could not write %s: %w", f.path, err)
+	}
+
+	f.forget()
+
+	return nil
+}
+
+// replace renames the output over the file at path, if any, giving it
+// the mode of that file.
+func (f *outputFile) replace() error {
+	if fi, err := os.Lstat(f.path); err == nil && fi.Mode().IsRegular() {
+		if err := os.Chmod(f.Name(), fi.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(f.Name(), f.path)
+}
+
+// link puts the output at path, failing if something appeared there
+// while writing. Linking fails atomically if path exists, unlike
+// checking before renaming.
+func (f *outputFile) link() error {
+	err := os.Link(f.Name(), f.path)
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("%s already exists, use --force to overwrite it", f.path)
+	case err != nil:
+		// Not all file systems have hard links, e.g. FAT
+		if err := checkOutput(f.path, false); err != nil {
+			return err
+		}
+
+		return os.Rename(f.Name(), f.path)
+	}
+
+	// Removing the temporary name of it is only tidying up
+	os.Remove(f.Name())
+
+	return nil
+}
+
+// Abort removes the output, unless already committed.
+func (f *outputFile) Abort() {
+	if f.done {
+		return
+	}
+
+	f.Close()
+	f.remove()
+}
+
+func (f *outputFile) remove() {
+	os.Remove(f.Name())
+	f.forget()
+}
+
+func (f *outputFile) forget() {
+	f.done = true
+
+	pendingOutputs.Lock()
+	delete(pendingOutputs.names, f.Name())
+	pendingOutputs.Unlock()
+}
+
+// writeOutput writes data to the output path, like createOutput and
+// Commit.
+func writeOutput(path string, data []byte, force bool) error {
+	f, err := createOutput(path, force)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("could not write %s: %w", path, err)
+	}
+
+	return f.Commit()
+}
+
+// removePendingOutputs removes the temporary files of all outputs not
+// yet complete, before quitting.
+func removePendingOutputs() {
+	pendingOutputs.Lock()
+	defer pendingOutputs.Unlock()
+
+	for name := range pendingOutputs.names {
+		os.Remove(name)
+	}
+	clear(pendingOutputs.names)
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// checkFile checks the contents and, where there are modes, the mode
+// of the file at path.
+func checkFile(t *testing.T, path string, want string, mode fs.FileMode) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != want {
+		t.Errorf("%s: got %q, %v, want %q", path, got, err, want)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != mode {
+		t.Errorf("%s: got mode %v, want %v", path, fi.Mode().Perm(), mode)
+	}
+}
+
+// checkNoTemp checks that no temporary files are left in dir.
+func checkNoTemp(t *testing.T, dir string, want int) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != want {
+		t.Errorf("%d files in %s, want %d", len(entries), dir, want)
+	}
+}
+
+func TestWriteOutput(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "random.bin")
+
+	if err := writeOutput(path, []byte("first"), false); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "first", 0o600)
+
+	if err := writeOutput(path, []byte("second"), false); err == nil {
+		t.Errorf("existing file without force, want an error")
+	}
+	checkFile(t, path, "first", 0o600)
+	checkNoTemp(t, dir, 1)
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutput(path, []byte("third"), true); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, path, "third", 0o644)
+	checkNoTemp(t, dir, 1)
+}
+
+func TestCommitAppeared(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "random.bin")
+
+	f, err := createOutput(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Abort()
+
+	if _, err := f.Write([]byte("random")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Something else creates it while writing
+	if err := os.WriteFile(path, []byte("other"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Commit(); err == nil {
+		t.Errorf("created while writing, want an error")
+	}
+	checkFile(t, path, "other", 0o644)
+	checkNoTemp(t, dir, 1)
+}
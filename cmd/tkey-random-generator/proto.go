@@ -4,7 +4,11 @@
 package main
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/tillitis/tkeyclient"
 )
@@ -18,8 +22,66 @@ var (
 	rspGetPubkey      = appCmd{0x06, "rspGetPubkey", tkeyclient.CmdLen128}
 	cmdGetSig         = appCmd{0x07, "cmdGetSig", tkeyclient.CmdLen1}
 	rspCmdSig         = appCmd{0x08, "rspCmdSig", tkeyclient.CmdLen128}
+	cmdGetStatus      = appCmd{0x09, "cmdGetStatus", tkeyclient.CmdLen1}
+	rspGetStatus      = appCmd{0x0a, "rspGetStatus", tkeyclient.CmdLen32}
+	cmdGetSelfTest    = appCmd{0x0b, "cmdGetSelfTest", tkeyclient.CmdLen1}
+	rspGetSelfTest    = appCmd{0x0c, "rspGetSelfTest", tkeyclient.CmdLen4}
+	cmdReseed         = appCmd{0x0d, "cmdReseed", tkeyclient.CmdLen1}
+	rspReseed         = appCmd{0x0e, "rspReseed", tkeyclient.CmdLen4}
+	cmdSetReseedTime  = appCmd{0x0f, "cmdSetReseedTime", tkeyclient.CmdLen32}
+	rspSetReseedTime  = appCmd{0x10, "rspSetReseedTime", tkeyclient.CmdLen4}
+	cmdSetNonce       = appCmd{0x11, "cmdSetNonce", tkeyclient.CmdLen128}
+	rspSetNonce       = appCmd{0x12, "rspSetNonce", tkeyclient.CmdLen4}
+	cmdSetContext     = appCmd{0x13, "cmdSetContext", tkeyclient.CmdLen128}
+	rspSetContext     = appCmd{0x14, "rspSetContext", tkeyclient.CmdLen4}
+	cmdEndSession     = appCmd{0x15, "cmdEndSession", tkeyclient.CmdLen1}
+	rspEndSession     = appCmd{0x16, "rspEndSession", tkeyclient.CmdLen4}
 )
 
+// MaxReseedInterval is the default, and longest, number of DRBG
+// rounds between reseeds from the TRNG. Each round produces 16 bytes.
+const MaxReseedInterval = 4096
+
+// The first version of the device app that knows about commands
+// beyond the original get random, pubkey and signature.
+const appVersionExtended = 2
+
+// The first version of the device app that can key the signature
+// session with a nonce.
+const appVersionNonce = 3
+
+// NonceMaxBytes is the longest nonce, which is the longest BLAKE2s
+// key.
+const NonceMaxBytes = 32
+
+// The first version of the device app that can bind a context label
+// into the signature.
+const appVersionContext = 4
+
+// ContextMaxBytes is the longest context label.
+const ContextMaxBytes = 64
+
+// The first version of the device app that can require a touch
+// before signing, when built to, and end a session without signing.
+const appVersionTouch = 5
+
+// Statuses of rspCmdSig when a touch is required before signing. The
+// device app first replies statusTouchWait, then again with the
+// signature once touched, or statusTouchTimeout.
+const (
+	statusTouchWait    = 2
+	statusTouchTimeout = 3
+)
+
+// ErrTouchTimeout is returned by GetSignature when the TKey wasn't
+// touched in time. The session is discarded without a signature.
+var ErrTouchTimeout = errors.New("TKey not touched in time, no signature made")
+
+// ErrNoSignature is returned by GetSignature when the TKey refuses to
+// sign, since it returned nothing since the last signature or its
+// self-test failed. There is no session to end.
+var ErrNoSignature = errors.New("TKey made no signature, nothing returned to sign or self-test failed")
+
 // cmdlen - (responsecode + status)
 var RandomPayloadMaxBytes = rspGetRandom.CmdLen().Bytelen() - (1 + 1)
 
@@ -46,7 +108,9 @@ func (c appCmd) String() string {
 }
 
 type RandomGen struct {
-	tk *tkeyclient.TillitisKey // A connection to a TKey
+	tk        *tkeyclient.TillitisKey // A connection to a TKey
+	firmware  *tkeyclient.NameVersion // The firmware that loaded the app, if known
+	appDigest string                  // SHA-512 of the app binary loaded, in hex, if known
 }
 
 // New allocates a struct for communicating with the random app
@@ -72,6 +136,19 @@ func (s RandomGen) Close() error {
 	return nil
 }
 
+// Firmware returns the name and version of the firmware that loaded
+// the device app, or nil if the app was already running when
+// connecting.
+func (s RandomGen) Firmware() *tkeyclient.NameVersion {
+	return s.firmware
+}
+
+// AppDigest returns the SHA-512 digest, in hex, of the device app
+// binary loaded when connecting, or "" if the app was already running.
+func (s RandomGen) AppDigest() string {
+	return s.appDigest
+}
+
 // GetAppNameVersion gets the name and version of the running app in
 // the same style as the stick itself.
 func (s RandomGen) GetAppNameVersion() (*tkeyclient.NameVersion, error) {
@@ -102,6 +179,14 @@ func (s RandomGen) GetAppNameVersion() (*tkeyclient.NameVersion, error) {
 
 // GetRandom fetches random data.
 func (s RandomGen) GetRandom(bytes int) ([]byte, error) {
+	start := time.Now()
+	random, err := s.getRandom(bytes)
+	metrics.observeFrame(len(random), time.Since(start), err)
+
+	return random, err
+}
+
+func (s RandomGen) getRandom(bytes int) ([]byte, error) {
 	if bytes < 1 || bytes > RandomPayloadMaxBytes {
 		return nil, fmt.Errorf("number of bytes is not in [1,%d]", RandomPayloadMaxBytes)
 	}
@@ -136,6 +221,22 @@ func (s RandomGen) GetRandom(bytes int) ([]byte, error) {
 	return rx[3 : 3+ret], nil
 }
 
+// Read fills p with random data, fetching it in as many frames as
+// needed. It implements io.Reader.
+func (s RandomGen) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		get := min(len(p)-n, RandomPayloadMaxBytes)
+		random, err := s.GetRandom(get)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], random)
+	}
+
+	return n, nil
+}
+
 // GetPubkey fetches the public key of the signer.
 func (s RandomGen) GetPubkey() ([]byte, error) {
 	id := 2
@@ -160,7 +261,10 @@ func (s RandomGen) GetPubkey() ([]byte, error) {
 }
 
 // GetSignature returns both the signature and the calculated hash
-// over the generated random data.
+// over the generated random data. If the device app requires a touch
+// before signing, it asks for one on stderr and waits for it, or
+// returns ErrTouchTimeout. ErrNoSignature is returned if there's
+// nothing to sign.
 func (s RandomGen) GetSignature() ([]byte, []byte, error) {
 	id := 2
 	tx, err := tkeyclient.NewFrameBuf(cmdGetSig, id)
@@ -179,6 +283,336 @@ func (s RandomGen) GetSignature() ([]byte, []byte, error) {
 		return nil, nil, fmt.Errorf("ReadFrame: %w", err)
 	}
 
+	if rx[2] == statusTouchWait {
+		le.Printf("Touch your TKey to sign...\n")
+
+		rx, _, err = s.tk.ReadFrame(rspCmdSig, id)
+		tkeyclient.Dump("GetSig rx", rx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ReadFrame: %w", err)
+		}
+	}
+
+	switch rx[2] {
+	case tkeyclient.StatusOK:
+	case statusTouchTimeout:
+		return nil, nil, ErrTouchTimeout
+	case tkeyclient.StatusBad:
+		return nil, nil, ErrNoSignature
+	default:
+		return nil, nil, fmt.Errorf("GetSig unknown status %d", rx[2])
+	}
+
+	metrics.signatures.Inc()
+
 	// Skipping frame header & app header
 	return rx[3 : 3+64], rx[3+64 : 3+64+32], nil
 }
+
+// Status is the state of the DRBG in the device app.
+type Status struct {
+	// RNGInitialized is true once the DRBG has been seeded.
+	RNGInitialized bool `json:"rng_initialized"`
+	// GenerateCalls is the number of random data requests served
+	// since the app was loaded.
+	GenerateCalls uint32 `json:"generate_calls"`
+	// ReseedCounter is the number of DRBG rounds since the last
+	// reseed from the TRNG.
+	ReseedCounter uint32 `json:"reseed_counter"`
+	// ReseedInterval is the number of DRBG rounds between reseeds.
+	ReseedInterval uint32 `json:"reseed_interval"`
+	// Reseeds is the number of reseeds since the app was loaded.
+	Reseeds uint32 `json:"reseeds"`
+	// SessionBytes is the number of bytes hashed into the current
+	// signature session.
+	SessionBytes uint32 `json:"session_bytes"`
+	// TouchTimeout is the number of seconds the device app was built
+	// to wait for a touch before signing, or 0 if no touch is
+	// required.
+	TouchTimeout uint8 `json:"touch_timeout"`
+}
+
+// Status fetches the state of the DRBG on the device app. Older
+// device apps don't know the command and don't reply at all, so use
+// a read timeout.
+func (s RandomGen) Status() (*Status, error) {
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdGetStatus, id)
+	if err != nil {
+		return nil, fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tkeyclient.Dump("GetStatus tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspGetStatus, id)
+	tkeyclient.Dump("GetStatus rx", rx)
+	if err != nil {
+		return nil, fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return nil, fmt.Errorf("GetStatus NOK")
+	}
+
+	// Skipping frame header, app header, and status
+	payload := rx[3:]
+	status := &Status{
+		RNGInitialized: payload[0] != 0,
+		GenerateCalls:  binary.LittleEndian.Uint32(payload[1:5]),
+		ReseedCounter:  binary.LittleEndian.Uint32(payload[5:9]),
+		ReseedInterval: binary.LittleEndian.Uint32(payload[9:13]),
+		Reseeds:        binary.LittleEndian.Uint32(payload[13:17]),
+		SessionBytes:   binary.LittleEndian.Uint32(payload[17:21]),
+		TouchTimeout:   payload[21],
+	}
+
+	return status, nil
+}
+
+// SelfTestResult has a bit set for each known-answer test that
+// failed when the device app started. Zero means all passed.
+type SelfTestResult uint8
+
+const (
+	SelfTestBLAKE2s       SelfTestResult = 1 << 0
+	SelfTestEd25519Sign   SelfTestResult = 1 << 1
+	SelfTestEd25519Verify SelfTestResult = 1 << 2
+)
+
+// Passed returns true if all the self-tests passed.
+func (r SelfTestResult) Passed() bool {
+	return r == 0
+}
+
+func (r SelfTestResult) String() string {
+	if r.Passed() {
+		return "passed"
+	}
+
+	var failed []string
+	if r&SelfTestBLAKE2s != 0 {
+		failed = append(failed, "BLAKE2s")
+	}
+	if r&SelfTestEd25519Sign != 0 {
+		failed = append(failed, "Ed25519 sign")
+	}
+	if r&SelfTestEd25519Verify != 0 {
+		failed = append(failed, "Ed25519 verify")
+	}
+	if r&^(SelfTestBLAKE2s|SelfTestEd25519Sign|SelfTestEd25519Verify) != 0 {
+		failed = append(failed, fmt.Sprintf("unknown (0x%02x)", uint8(r)))
+	}
+
+	return "FAILED: " + strings.Join(failed, ", ")
+}
+
+// SelfTest fetches the result of the known-answer tests the device
+// app ran when it started. A device app with failed self-tests
+// refuses to return random data or signatures.
+func (s RandomGen) SelfTest() (SelfTestResult, error) {
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdGetSelfTest, id)
+	if err != nil {
+		return 0, fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tkeyclient.Dump("GetSelfTest tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return 0, fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspGetSelfTest, id)
+	tkeyclient.Dump("GetSelfTest rx", rx)
+	if err != nil {
+		return 0, fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return 0, fmt.Errorf("GetSelfTest NOK")
+	}
+
+	return SelfTestResult(rx[3]), nil
+}
+
+// Reseed makes the device app immediately reseed its DRBG from the
+// TRNG.
+func (s RandomGen) Reseed() error {
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdReseed, id)
+	if err != nil {
+		return fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tkeyclient.Dump("Reseed tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspReseed, id)
+	tkeyclient.Dump("Reseed rx", rx)
+	if err != nil {
+		return fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return fmt.Errorf("Reseed NOK")
+	}
+
+	return nil
+}
+
+// SetReseedInterval sets the number of DRBG rounds between reseeds
+// from the TRNG until the device app is restarted. The interval can
+// only be lowered from the default, so it must be in
+// [1,MaxReseedInterval].
+func (s RandomGen) SetReseedInterval(rounds uint32) error {
+	if rounds < 1 || rounds > MaxReseedInterval {
+		return fmt.Errorf("reseed interval is not in [1,%d]", MaxReseedInterval)
+	}
+
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdSetReseedTime, id)
+	if err != nil {
+		return fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	binary.LittleEndian.PutUint32(tx[2:], rounds)
+	tkeyclient.Dump("SetReseedTime tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspSetReseedTime, id)
+	tkeyclient.Dump("SetReseedTime rx", rx)
+	if err != nil {
+		return fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return fmt.Errorf("SetReseedTime NOK")
+	}
+
+	return nil
+}
+
+// SetNonce keys the hash of the signature session with nonce, so that
+// the signature proves the random data was generated after the nonce
+// was known. It must be called before any random data is fetched in
+// the session.
+func (s RandomGen) SetNonce(nonce []byte) error {
+	if len(nonce) < 1 || len(nonce) > NonceMaxBytes {
+		return fmt.Errorf("nonce length is not in [1,%d]", NonceMaxBytes)
+	}
+
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdSetNonce, id)
+	if err != nil {
+		return fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tx[2] = byte(len(nonce))
+	copy(tx[3:], nonce)
+	tkeyclient.Dump("SetNonce tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspSetNonce, id)
+	tkeyclient.Dump("SetNonce rx", rx)
+	if err != nil {
+		return fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return fmt.Errorf("SetNonce NOK, is there random data in the session already?")
+	}
+
+	return nil
+}
+
+// SetContext binds the context label into the hash of the signature
+// session, so that a signature made for one purpose can't be passed
+// off as made for another. It must be called before any random data
+// is fetched in the session, and after SetNonce, if used.
+func (s RandomGen) SetContext(label []byte) error {
+	if len(label) < 1 || len(label) > ContextMaxBytes {
+		return fmt.Errorf("context label length is not in [1,%d]", ContextMaxBytes)
+	}
+
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdSetContext, id)
+	if err != nil {
+		return fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tx[2] = byte(len(label))
+	copy(tx[3:], label)
+	tkeyclient.Dump("SetContext tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspSetContext, id)
+	tkeyclient.Dump("SetContext rx", rx)
+	if err != nil {
+		return fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return fmt.Errorf("SetContext NOK, is there random data in the session already?")
+	}
+
+	return nil
+}
+
+// EndSession discards the random data returned since the last
+// signature without signing it, so unlike GetSignature it never waits
+// for a touch.
+func (s RandomGen) EndSession() error {
+	id := 2
+	tx, err := tkeyclient.NewFrameBuf(cmdEndSession, id)
+	if err != nil {
+		return fmt.Errorf("NewFrameBuf: %w", err)
+	}
+
+	tkeyclient.Dump("EndSession tx", tx)
+	if err = s.tk.Write(tx); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+
+	defer s.tk.SetReadTimeoutNoErr(0)
+	s.tk.SetReadTimeoutNoErr(2)
+
+	rx, _, err := s.tk.ReadFrame(rspEndSession, id)
+	tkeyclient.Dump("EndSession rx", rx)
+	if err != nil {
+		return fmt.Errorf("ReadFrame: %w", err)
+	}
+
+	if rx[2] != tkeyclient.StatusOK {
+		return fmt.Errorf("EndSession NOK")
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"tkey-random-generator/randverify"
+)
+
+// The formats of the segment manifest, see
+// randverify.SegmentManifest.
+type (
+	segmentManifest = randverify.SegmentManifest
+	segment         = randverify.Segment
+)
+
+// parseSegmentSize parses the value of --segment-size, in bytes or
+// with a unit like 64MiB.
+func parseSegmentSize(value string) (int64, error) {
+	size, err := humanize.ParseBytes(value)
+	if err != nil || size < 1 || size > 1<<62 {
+		return 0, fmt.Errorf("--segment-size needs to be a size like 1048576 or 1MiB")
+	}
+
+	return int64(size), nil
+}
+
+// segmenter reads random data from the TKey and ends the signature
+// session every size bytes, collecting the signature of every
+// segment. Every segment is keyed with the randverify.SegmentNonce of
+// nonce and its offset. It checks every hash against its own and every
+// signature against pubkey as it goes.
+type segmenter struct {
+	rg         RandomGen
+	appVersion uint32
+	size       int64
+	nonce      []byte
+	context    string
+	pubkey     []byte
+
+	h        hash.Hash
+	offset   int64
+	n        int64
+	segments []segment
+}
+
+// newSegmenter returns a segmenter reading from randomGen, running a
+// device app of appVersion, and starts the session of the first
+// segment. The nonce and context label must not be set already.
+func newSegmenter(randomGen RandomGen, appVersion uint32, size int64, nonce []byte, context string) (*segmenter, error) {
+	pubkey, err := randomGen.GetPubkey()
+	if err != nil {
+		return nil, fmt.Errorf("GetPubkey failed: %w", err)
+	}
+
+	s := &segmenter{
+		rg:         randomGen,
+		appVersion: appVersion,
+		size:       size,
+		nonce:      nonce,
+		context:    context,
+		pubkey:     pubkey,
+	}
+	if err := s.start(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Read fills p with random data, at most up to the end of the current
+// segment. It implements io.Reader.
+func (s *segmenter) Read(p []byte) (int, error) {
+	if s.n == s.size {
+		if err := s.end(); err != nil {
+			return 0, err
+		}
+		if err := s.start(); err != nil {
+			return 0, err
+		}
+	}
+
+	p = p[:min(int64(len(p)), s.size-s.n)]
+	n, err := s.rg.Read(p)
+	s.h.Write(p[:n])
+	s.n += int64(n)
+
+	return n, err
+}
+
+// start starts the signature session of the next segment.
+func (s *segmenter) start() error {
+	nonce := randverify.SegmentNonce(s.nonce, s.offset)
+	if err := setNonce(s.rg, s.appVersion, nonce); err != nil {
+		return err
+	}
+
+	if s.context != "" {
+		if err := setContext(s.rg, s.appVersion, s.context); err != nil {
+			return err
+		}
+	}
+
+	h, err := randverify.NewHash(nonce, s.context)
+	if err != nil {
+		return err
+	}
+	s.h = h
+
+	return nil
+}
+
+// end ends the signature session of the current segment and checks
+// its signature.
+func (s *segmenter) end() error {
+	signature, digest, err := s.rg.GetSignature()
+	if err != nil {
+		return fmt.Errorf("GetSig failed for segment at offset %d: %w", s.offset, err)
+	}
+
+	if !bytes.Equal(digest, s.h.Sum(nil)) {
+		return fmt.Errorf("hash of segment at offset %d FAILED verification: hash not equal", s.offset)
+	}
+
+	if !ed25519.Verify(s.pubkey, digest, signature) {
+		return fmt.Errorf("signature of segment at offset %d FAILED verification", s.offset)
+	}
+
+	s.segments = append(s.segments, segment{
+		Offset:    s.offset,
+		Length:    s.n,
+		Hash:      hex.EncodeToString(digest),
+		Signature: hex.EncodeToString(signature),
+	})
+	s.offset += s.n
+	s.n = 0
+
+	return nil
+}
+
+// finish ends the signature session of the last segment and returns
+// the manifest of all segments.
+func (s *segmenter) finish(file string) (segmentManifest, error) {
+	if s.n > 0 {
+		if err := s.end(); err != nil {
+			return segmentManifest{}, err
+		}
+	}
+
+	return segmentManifest{
+		Version:     randverify.SegmentVersion,
+		File:        file,
+		Bytes:       s.offset,
+		SegmentSize: s.size,
+		Pubkey:      hex.EncodeToString(s.pubkey),
+		Nonce:       hex.EncodeToString(s.nonce),
+		Scheme:      randverify.Scheme(s.context),
+		Context:     s.context,
+		Segments:    s.segments,
+	}, nil
+}
+
+// byteRange is a part of the output, from --range.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+// parseRange parses the value of --range: OFFSET:LENGTH in bytes.
+func parseRange(value string) (*byteRange, error) {
+	offset, length, ok := strings.Cut(value, ":")
+	if ok {
+		r := &byteRange{}
+		var err1, err2 error
+		r.offset, err1 = strconv.ParseInt(offset, 10, 64)
+		r.length, err2 = strconv.ParseInt(length, 10, 64)
+		if err1 == nil && err2 == nil && r.offset >= 0 && r.length > 0 && r.offset <= (1<<62)-r.length {
+			return r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("--range needs to be OFFSET:LENGTH in bytes, with LENGTH larger than 0")
+}
+
+// readManifest reads a segment manifest from path.
+func readManifest(path string) (*segmentManifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	defer f.Close()
+
+	m, err := randverify.ReadSegmentManifest(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return m, nil
+}
+
+// runVerifySegments verifies the segments of fileRandData with the
+// manifest in fileManifest, listing those failing. It returns the exit
+// code.
+func runVerifySegments(fileManifest string, fileRandData string, filePubkey string, isBinary bool, nonce []byte, context string, rng *byteRange) int {
+	pubkey, err := readPubkey(filePubkey)
+	if err != nil {
+		le.Printf("Error reading public key: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Public key: %x\n", pubkey)
+	le.Printf("Verifying segment signatures ...\n")
+
+	problems, verified, err := verifySegments(fileManifest, fileRandData, pubkey, isBinary, nonce, context, rng)
+	if err != nil {
+		le.Printf("Error verifying: %v\n", err)
+		return 1
+	}
+
+	for _, problem := range problems {
+		le.Printf("%s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		le.Printf("Segments FAILED verification: %d problems, %d segments verified.\n", len(problems), verified)
+		return 1
+	}
+
+	if rng != nil {
+		le.Printf("Bytes %d to %d verified by %d segments.\n", rng.offset, rng.offset+rng.length-1, verified)
+	} else {
+		le.Printf("All %d segments verified.\n", verified)
+	}
+
+	return 0
+}
+
+// verifySegments verifies the segments of the random data in
+// fileRandData listed in the manifest in fileManifest against the
+// public key pubkey, all of them or only those overlapping rng
+// if not nil. It returns the problems found, one per failing segment
+// or inconsistency, and the number of segments verified.
+func verifySegments(fileManifest string, fileRandData string, pubkey []byte, isBinary bool, nonce []byte, context string, rng *byteRange) ([]string, int, error) {
+	m, err := readManifest(fileManifest)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Report what the signatures can't verify with, rather than every
+	// segment failing
+	if m.Pubkey != hex.EncodeToString(pubkey) {
+		return nil, 0, fmt.Errorf("manifest is signed by public key %s, not this one", m.Pubkey)
+	}
+	if m.Nonce != hex.EncodeToString(nonce) {
+		return nil, 0, fmt.Errorf("manifest has nonce %q, expected %q", m.Nonce, hex.EncodeToString(nonce))
+	}
+	if m.Context != context {
+		return nil, 0, fmt.Errorf("manifest has context %q, expected %q", m.Context, context)
+	}
+
+	var data io.ReaderAt
+	var size int64
+	switch {
+	case isBinary && fileRandData != "-" && !isInline(fileRandData):
+		// Large outputs are the reason for segments, so don't read
+		// them all
+		f, err := os.Open(fileRandData)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not read %s: %w", fileRandData, err)
+		}
+		defer f.Close()
+
+		info, err := f.Stat()
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not read %s: %w", fileRandData, err)
+		}
+		data, size = f, info.Size()
+	default:
+		var message []byte
+		if isBinary {
+			var r io.ReadCloser
+			r, err = openBinaryInput(fileRandData)
+			if err == nil {
+				message, err = io.ReadAll(r)
+				r.Close()
+			}
+		} else {
+			message, err = readEncodedInput("random data", fileRandData, 0)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		data, size = bytes.NewReader(message), int64(len(message))
+	}
+
+	var res *randverify.Result
+	if rng == nil {
+		res, err = randverify.VerifySegments(m, io.NewSectionReader(data, 0, size))
+	} else {
+		res, err = randverify.VerifySegmentRange(m, data, rng.offset, rng.length)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return res.Problems, res.Verified, nil
+}
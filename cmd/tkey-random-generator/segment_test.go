@@ -0,0 +1,113 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tkey-random-generator/randverify"
+)
+
+// signSegments signs data in segments of size bytes like the device
+// app does for generate --segment-size, returning the manifest.
+func signSegments(t *testing.T, key ed25519.PrivateKey, data []byte, size int64, nonce []byte) *segmentManifest {
+	t.Helper()
+
+	m := &segmentManifest{
+		Version:     randverify.SegmentVersion,
+		Bytes:       int64(len(data)),
+		SegmentSize: size,
+		Pubkey:      hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Nonce:       hex.EncodeToString(nonce),
+		Scheme:      randverify.SchemeLegacy,
+	}
+
+	for offset := int64(0); offset < m.Bytes; offset += size {
+		end := min(offset+size, m.Bytes)
+		digest, err := randverify.Sum(randverify.SegmentNonce(nonce, offset), "", data[offset:end])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m.Segments = append(m.Segments, segment{
+			Offset:    offset,
+			Length:    end - offset,
+			Hash:      hex.EncodeToString(digest),
+			Signature: hex.EncodeToString(ed25519.Sign(key, digest)),
+		})
+	}
+
+	return m
+}
+
+// writeSegments writes data and the manifest m to files, returning
+// their paths.
+func writeSegments(t *testing.T, data []byte, m *segmentManifest) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	dataPath := filepath.Join(dir, "random.bin")
+	manifestPath := filepath.Join(dir, "manifest.json")
+
+	manifest, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(dataPath, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(manifestPath, manifest, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataPath, manifestPath
+}
+
+func TestVerifySegments(t *testing.T) {
+	t.Parallel()
+
+	pubkey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 4*64+10)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, nonce := range [][]byte{nil, []byte("challenge")} {
+		m := signSegments(t, key, data, 64, nonce)
+
+		dataPath, manifestPath := writeSegments(t, data, m)
+		problems, verified, err := verifySegments(manifestPath, dataPath, pubkey, true, nonce, "", nil)
+		if err != nil || len(problems) > 0 || verified != 5 {
+			t.Errorf("nonce %q: %d verified, %v, %v", nonce, verified, problems, err)
+		}
+
+		problems, verified, err = verifySegments(manifestPath, dataPath, pubkey, true, nonce, "", &byteRange{offset: 100, length: 50})
+		if err != nil || len(problems) > 0 || verified != 2 {
+			t.Errorf("nonce %q, range: %d verified, %v, %v", nonce, verified, problems, err)
+		}
+
+		// Swap the first two segments, in the data and the manifest
+		swapped := append(append(append([]byte{}, data[64:128]...), data[:64]...), data[128:]...)
+		m.Segments[0].Hash, m.Segments[1].Hash = m.Segments[1].Hash, m.Segments[0].Hash
+		m.Segments[0].Signature, m.Segments[1].Signature = m.Segments[1].Signature, m.Segments[0].Signature
+
+		dataPath, manifestPath = writeSegments(t, swapped, m)
+		problems, _, err = verifySegments(manifestPath, dataPath, pubkey, true, nonce, "", nil)
+		if err != nil || len(problems) != 2 || !strings.HasPrefix(problems[0], "Segment 0") || !strings.HasPrefix(problems[1], "Segment 1") {
+			t.Errorf("nonce %q, swapped: %v, %v", nonce, problems, err)
+		}
+	}
+}
@@ -0,0 +1,585 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/spf13/pflag"
+	"tkey-random-generator/randsock"
+)
+
+// schedulerQuantum is the most bytes handed to one request before
+// moving on to the next one.
+const schedulerQuantum = 4096
+
+var errClosed = errors.New("closed")
+
+// serveOptions are the settings of the serve subcommand.
+type serveOptions struct {
+	socket     string
+	socketMode string
+	buffer     int
+	lowWater   int
+	verbose    bool
+	metrics    string
+	// auditLog is the audit log to append the signatures of the
+	// sessions to when stopping, if any
+	auditLog string
+}
+
+// runServe is the subcommand owning the TKey and serving random data
+// to other processes over a Unix domain socket. It returns the exit
+// code.
+func runServe(args []string) int {
+	var dev deviceFlags
+	var opts serveOptions
+	var helpOnly bool
+
+	cmdServe := pflag.NewFlagSet("serve", pflag.ExitOnError)
+	cmdServe.SortFlags = false
+	dev.register(cmdServe)
+	dev.registerMulti(cmdServe)
+	cmdServe.StringVar(&opts.socket, "socket", randsock.DefaultPath(),
+		"Listen on Unix domain socket `PATH`.")
+	cmdServe.StringVar(&opts.socketMode, "socket-mode", "0660",
+		"Set the permissions of the socket to `MODE`, in octal.")
+	cmdServe.IntVar(&opts.buffer, "buffer", 64*1024,
+		"Keep up to `BYTES` of random data prefetched from the TKey.")
+	cmdServe.IntVar(&opts.lowWater, "low-water", 16*1024,
+		"Refill the prefetch buffer when it holds `BYTES` or less.")
+	cmdServe.StringVar(&opts.auditLog, "audit-log", "",
+		"When stopping, have every TKey sign all it returned and append the signatures to the audit log `FILE`.")
+	registerMetricsFlag(cmdServe, &opts.metrics)
+	cmdServe.BoolVarP(&opts.verbose, "verbose", "v", false, "Log connections and requests.")
+	cmdServe.BoolVarP(&helpOnly, "help", "h", false, "Output this help.")
+	cmdServe.Usage = func() {
+		desc := fmt.Sprintf(`Usage: %[1]s serve [flags..]
+
+  Keeps the device app loaded and serves random data from the TKey to
+  other processes over a Unix domain socket, so that several of them
+  can share one TKey.
+
+  Random data is prefetched into a buffer, refilled in the background
+  when it drops to the low watermark, and handed out to clients
+  round-robin so a large request doesn't hold up small ones. All data
+  is run through continuous health tests. If the TKey fails, or data
+  fails a health test, the daemon exits with an error.
+
+  See the randsock package for the protocol and a client.
+
+  Usage:`, os.Args[0])
+		le.Printf("%s\n\n%s", desc,
+			cmdServe.FlagUsagesWrapped(80))
+	}
+
+	if err := cmdServe.Parse(args); err != nil {
+		le.Printf("Error parsing input arguments: %v\n", err)
+		return 2
+	}
+
+	if helpOnly {
+		cmdServe.Usage()
+		return 0
+	}
+
+	if cmdServe.NArg() > 0 {
+		le.Printf("Unexpected argument: %s\n\n", strings.Join(cmdServe.Args(), " "))
+		cmdServe.Usage()
+		return 2
+	}
+
+	if err := dev.validate(); err != nil {
+		le.Printf("%v\n\n", err)
+		cmdServe.Usage()
+		return 2
+	}
+
+	mode, err := opts.validate()
+	if err != nil {
+		le.Printf("%v\n\n", err)
+		cmdServe.Usage()
+		return 2
+	}
+
+	handle := func(conn net.Conn, sched *fairScheduler, _ *prefetchPool) {
+		handleRandsockConn(conn, sched, opts.verbose)
+	}
+
+	if err := serveSocket(dev, opts, mode, handle); err != nil {
+		le.Printf("Error serving: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// validate checks the options and returns the socket permissions.
+func (o serveOptions) validate() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(o.socketMode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("--socket-mode needs to be octal permissions, like 0660")
+	}
+
+	if o.buffer < 1 || o.lowWater < 0 || o.lowWater >= o.buffer {
+		return 0, fmt.Errorf("--low-water needs to be less than --buffer")
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// serveSocket connects to the TKey, starts prefetching random data
+// and runs handle for every connection on the Unix domain socket
+// until interrupted or the TKey fails. If the TKey is unplugged it's
+// reconnected, while clients wait.
+func serveSocket(dev deviceFlags, opts serveOptions, mode os.FileMode,
+	handle func(net.Conn, *fairScheduler, *prefetchPool),
+) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	device, err := dev.superviseAll(sigCtx)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	if opts.auditLog != "" {
+		if err := device.trackSessions(nil, ""); err != nil {
+			return err
+		}
+	}
+
+	ln, err := activationListener()
+	if err != nil {
+		return err
+	}
+	if ln == nil {
+		ln, err = listenUnix(opts.socket, mode)
+		if err != nil {
+			return err
+		}
+	} else {
+		opts.socket = ln.Addr().String()
+	}
+
+	if err := serveMetrics(sigCtx, opts.metrics); err != nil {
+		ln.Close()
+		return err
+	}
+
+	pool := newPrefetchPool(device, opts.buffer, opts.lowWater)
+	poolDone := make(chan struct{})
+	go func() {
+		defer close(poolDone)
+		if err := pool.run(); err != nil {
+			cancel(err)
+		}
+	}()
+
+	sched := newFairScheduler(pool, schedulerQuantum)
+	go sched.run()
+
+	// Wake up handlers waiting for data when shutting down
+	go func() {
+		<-sigCtx.Done()
+		sched.Close()
+		pool.Close()
+	}()
+
+	le.Printf("Serving random data on %s\n", opts.socket)
+	notifyReady(sigCtx, "Serving random data on "+opts.socket)
+	err = serveConns(sigCtx, ln, opts.verbose, func(conn net.Conn) {
+		handle(conn, sched, pool)
+	})
+
+	sdNotify("STOPPING=1")
+
+	// Stop using the TKey before talking to it here
+	sched.Close()
+	pool.Close()
+	<-poolDone
+
+	// Re-init the hash on the TKey, signing what it returned for the
+	// audit log
+	if opts.auditLog == "" {
+		device.endSessions()
+	} else {
+		err = errors.Join(err, device.auditSessions(opts.auditLog, "serve"))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+		return cause
+	}
+
+	le.Printf("Stopped.\n")
+
+	return nil
+}
+
+// listenUnix listens on a Unix domain socket at path with
+// permissions mode. A stale socket left by a daemon that died is
+// removed, but not one that's still in use.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("could not set permissions on %s: %w", path, err)
+	}
+
+	return ln, nil
+}
+
+// serveConns accepts connections on ln and runs handle for each of
+// them in its own goroutine until ctx is done. It then closes the
+// listener and all connections and waits for the handlers to return.
+func serveConns(ctx context.Context, ln net.Listener, verbose bool, handle func(net.Conn)) error {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conns := map[net.Conn]struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+
+	defer wg.Wait()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		mu.Lock()
+		if ctx.Err() != nil {
+			mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		if verbose {
+			le.Printf("Client connected\n")
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+				conn.Close()
+			}()
+
+			handle(conn)
+		}()
+	}
+}
+
+// handleRandsockConn serves randsock requests on conn until the
+// client goes away.
+func handleRandsockConn(conn net.Conn, sched *fairScheduler, verbose bool) {
+	for {
+		n, err := randsock.ReadRequest(conn)
+		if err != nil {
+			if verbose && !errors.Is(err, io.EOF) {
+				le.Printf("Bad request: %v\n", err)
+			}
+			return
+		}
+
+		data, err := sched.Get(n)
+		if err != nil {
+			_ = randsock.WriteResponse(conn, randsock.StatusErr, []byte(err.Error()))
+			return
+		}
+
+		if verbose {
+			le.Printf("Served %d bytes\n", n)
+		}
+
+		if err := randsock.WriteResponse(conn, randsock.StatusOK, data); err != nil {
+			return
+		}
+	}
+}
+
+// prefetchPool keeps a buffer of random data read from src. It's
+// refilled to size in the background whenever it drops to the low
+// watermark. Data is run through health tests before it's added.
+type prefetchPool struct {
+	src  io.Reader
+	size int
+	low  int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	err    error
+	closed bool
+	health healthTester
+}
+
+func newPrefetchPool(src io.Reader, size int, low int) *prefetchPool {
+	p := &prefetchPool{
+		src:  src,
+		size: size,
+		low:  low,
+		buf:  make([]byte, 0, size),
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	return p
+}
+
+// run refills the pool until it's closed or reading from the source
+// fails, in which case the error is returned and also given to
+// everyone taking from the pool.
+func (p *prefetchPool) run() error {
+	chunk := make([]byte, 8*RandomPayloadMaxBytes)
+
+	for {
+		p.mu.Lock()
+		for !p.closed && len(p.buf) > p.low {
+			p.cond.Wait()
+		}
+		p.mu.Unlock()
+
+		// Fill it up
+		for {
+			p.mu.Lock()
+			room := p.size - len(p.buf)
+			closed := p.closed
+			p.mu.Unlock()
+
+			if closed {
+				return nil
+			}
+			if room <= 0 {
+				break
+			}
+
+			n := min(room, len(chunk))
+			_, err := io.ReadFull(p.src, chunk[:n])
+			if err != nil {
+				err = fmt.Errorf("could not read random data: %w", err)
+			} else {
+				err = p.health.Check(chunk[:n])
+			}
+
+			p.mu.Lock()
+			if err != nil {
+				p.err = err
+				p.cond.Broadcast()
+				p.mu.Unlock()
+				return err
+			}
+			p.buf = append(p.buf, chunk[:n]...)
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// Take returns at most n bytes from the pool, waiting for a refill if
+// it's empty.
+func (p *prefetchPool) Take(n int) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.buf) == 0 && p.err == nil && !p.closed {
+		p.cond.Wait()
+	}
+
+	return p.take(n)
+}
+
+// TryTake returns at most n bytes from the pool without waiting. The
+// result is empty if the pool is.
+func (p *prefetchPool) TryTake(n int) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.take(n)
+}
+
+// Available returns the number of bytes in the pool.
+func (p *prefetchPool) Available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.buf)
+}
+
+// take removes at most n bytes from the pool. Must be called with mu
+// held.
+func (p *prefetchPool) take(n int) ([]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if p.closed {
+		return nil, errClosed
+	}
+
+	k := min(n, len(p.buf))
+	out := make([]byte, k)
+	copy(out, p.buf)
+	// Don't leave handed out data behind in the buffer
+	clear(p.buf[:k])
+	p.buf = p.buf[k:]
+
+	if len(p.buf) <= p.low {
+		p.cond.Broadcast()
+	}
+
+	return out, nil
+}
+
+// Close stops the refilling and wakes up everyone waiting.
+func (p *prefetchPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// fairScheduler hands out random data from a pool to pending
+// requests round-robin, at most quantum bytes at a time, so one large
+// request doesn't starve small ones.
+type fairScheduler struct {
+	pool    *prefetchPool
+	quantum int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []*randRequest
+	closed bool
+}
+
+type randRequest struct {
+	remaining int
+	data      []byte
+	done      chan error
+}
+
+func newFairScheduler(pool *prefetchPool, quantum int) *fairScheduler {
+	s := &fairScheduler{
+		pool:    pool,
+		quantum: quantum,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// Get returns n bytes of random data, waiting for its turn.
+func (s *fairScheduler) Get(n int) ([]byte, error) {
+	req := &randRequest{
+		remaining: n,
+		data:      make([]byte, 0, n),
+		done:      make(chan error, 1),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errClosed
+	}
+	s.queue = append(s.queue, req)
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	if err := <-req.done; err != nil {
+		return nil, err
+	}
+
+	return req.data, nil
+}
+
+// run serves the queued requests until the scheduler is closed.
+func (s *fairScheduler) run() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			for _, req := range s.queue {
+				req.done <- errClosed
+			}
+			s.queue = nil
+			s.mu.Unlock()
+			return
+		}
+		req := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+
+		piece, err := s.pool.Take(min(req.remaining, s.quantum))
+		if err != nil {
+			req.done <- err
+			continue
+		}
+
+		req.data = append(req.data, piece...)
+		req.remaining -= len(piece)
+		if req.remaining == 0 {
+			req.done <- nil
+			continue
+		}
+
+		s.mu.Lock()
+		s.queue = append(s.queue, req)
+		s.mu.Unlock()
+	}
+}
+
+// Close fails all pending requests and stops the scheduler.
+func (s *fairScheduler) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
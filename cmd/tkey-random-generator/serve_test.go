@@ -0,0 +1,168 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"tkey-random-generator/randsock"
+)
+
+// startPool returns a prefetch pool of src, refilling in the
+// background until the test ends.
+func startPool(t *testing.T, size int, low int, src io.Reader) *prefetchPool {
+	t.Helper()
+
+	pool := newPrefetchPool(src, size, low)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = pool.run()
+	}()
+	t.Cleanup(func() {
+		pool.Close()
+		<-done
+	})
+
+	return pool
+}
+
+func TestPrefetchPool(t *testing.T) {
+	t.Parallel()
+
+	pool := startPool(t, 4096, 1024, newFakeTKey(t, rand.Reader))
+
+	total := 0
+	for total < 64*1024 {
+		data, err := pool.Take(3000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) < 1 || len(data) > 3000 {
+			t.Fatalf("took %d bytes, want 1 to 3000", len(data))
+		}
+		if pool.Available() > 4096 {
+			t.Fatalf("%d bytes in a pool of 4096", pool.Available())
+		}
+		total += len(data)
+	}
+
+	pool.Close()
+	if _, err := pool.Take(1); !errors.Is(err, errClosed) {
+		t.Errorf("closed pool: got %v, want errClosed", err)
+	}
+}
+
+func TestPrefetchPoolStuck(t *testing.T) {
+	t.Parallel()
+
+	pool := startPool(t, 4096, 1024, newFakeTKey(t, zeroReader{}))
+
+	if _, err := pool.Take(16); !errors.Is(err, errHealthTest) {
+		t.Errorf("got %v, want a health test failure", err)
+	}
+}
+
+func TestFairScheduler(t *testing.T) {
+	t.Parallel()
+
+	// The pool only has what's written to src
+	r, src := io.Pipe()
+	pool := startPool(t, 2048, 1024, newFakeTKey(t, r))
+	t.Cleanup(func() { src.Close() })
+	sched := newFairScheduler(pool, 1024)
+
+	// Queue a large request, then a small one, before serving them
+	type result struct {
+		n   int
+		err error
+	}
+	results := make(chan result, 2)
+	get := func(n int) {
+		data, err := sched.Get(n)
+		results <- result{len(data), err}
+	}
+
+	go get(32 * 1024)
+	waitQueued(sched, 1)
+	go get(100)
+	waitQueued(sched, 2)
+
+	go sched.run()
+	defer sched.Close()
+
+	// The small one gets its turn after one quantum of the large one,
+	// which then waits for more
+	if _, err := io.CopyN(src, rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; res.err != nil || res.n != 100 {
+		t.Errorf("first request done: %d bytes, %v, want the small one", res.n, res.err)
+	}
+
+	pool.Close()
+	if res := <-results; !errors.Is(res.err, errClosed) {
+		t.Errorf("large request: got %d bytes, %v, want errClosed", res.n, res.err)
+	}
+
+	sched.Close()
+	if _, err := sched.Get(1); !errors.Is(err, errClosed) {
+		t.Errorf("closed scheduler: got %v, want errClosed", err)
+	}
+}
+
+// waitQueued waits until n requests are queued in sched.
+func waitQueued(sched *fairScheduler, n int) {
+	for {
+		sched.mu.Lock()
+		queued := len(sched.queue)
+		sched.mu.Unlock()
+
+		if queued >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHandleRandsockConn(t *testing.T) {
+	t.Parallel()
+
+	pool := startPool(t, 64*1024, 16*1024, newFakeTKey(t, rand.Reader))
+	sched := newFairScheduler(pool, schedulerQuantum)
+	go sched.run()
+	defer sched.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handleRandsockConn(server, sched, false)
+		server.Close()
+	}()
+
+	// Several requests on the same connection
+	for _, n := range []int{1, 32, randsock.MaxRequest} {
+		if err := randsock.WriteRequest(client, n); err != nil {
+			t.Fatal(err)
+		}
+		data, err := randsock.ReadResponse(client, n)
+		if err != nil || len(data) != n {
+			t.Fatalf("request for %d bytes: got %d, %v", n, len(data), err)
+		}
+	}
+
+	// A bad request ends the connection
+	if _, err := client.Write([]byte{0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
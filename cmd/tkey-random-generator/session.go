@@ -0,0 +1,106 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// nonceRandom is the value of --nonce asking for a random nonce.
+const nonceRandom = "random"
+
+// parseNonce parses the value of --nonce: a nonce in hex or, if
+// allowRandom, "random" for a random one of the longest length.
+func parseNonce(value string, allowRandom bool) ([]byte, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if allowRandom && value == nonceRandom {
+		nonce := make([]byte, NonceMaxBytes)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("could not make nonce: %w", err)
+		}
+
+		return nonce, nil
+	}
+
+	nonce, err := hex.DecodeString(value)
+	if err != nil || len(nonce) < 1 || len(nonce) > NonceMaxBytes {
+		return nil, fmt.Errorf("--nonce needs to be 1 to %d bytes in hex", NonceMaxBytes)
+	}
+
+	return nonce, nil
+}
+
+// errAppTooOld returns the error of using feature, needing device app
+// version need, with a TKey running version have, as the embedded app
+// may be.
+func errAppTooOld(feature string, need uint32, have uint32) error {
+	return fmt.Errorf("%s: needs device app version %d, but the TKey runs version %d, load a newer one with --app", feature, need, have)
+}
+
+// setNonce keys the signature session on randomGen, running a device
+// app of appVersion, with nonce.
+func setNonce(randomGen tkeyDevice, appVersion uint32, nonce []byte) error {
+	if appVersion < appVersionNonce {
+		return errAppTooOld("nonces", appVersionNonce, appVersion)
+	}
+
+	if err := randomGen.SetNonce(nonce); err != nil {
+		return fmt.Errorf("SetNonce failed: %w", err)
+	}
+
+	return nil
+}
+
+// checkContext returns an error if label can't be used as a context
+// label.
+func checkContext(label string) error {
+	if len(label) > ContextMaxBytes {
+		return fmt.Errorf("--context needs to be at most %d bytes", ContextMaxBytes)
+	}
+
+	return nil
+}
+
+// setContext binds the context label into the signature session on
+// randomGen, running a device app of appVersion.
+func setContext(randomGen RandomGen, appVersion uint32, label string) error {
+	if appVersion < appVersionContext {
+		return errAppTooOld("context labels", appVersionContext, appVersion)
+	}
+
+	if err := randomGen.SetContext([]byte(label)); err != nil {
+		return fmt.Errorf("SetContext failed: %w", err)
+	}
+
+	return nil
+}
+
+// endSession discards the unsigned random data in the signature
+// session on randomGen, running a device app of appVersion. Device
+// apps that can require a touch before signing end it without
+// signing, so that nobody is asked to touch the TKey for data that
+// isn't signed. Older ones can't require a touch, and end it by
+// signing.
+func endSession(randomGen tkeyDevice, appVersion uint32) error {
+	if appVersion < appVersionTouch {
+		// With nothing returned there is nothing to sign
+		if _, _, err := randomGen.GetSignature(); err != nil && !errors.Is(err, ErrNoSignature) {
+			return fmt.Errorf("GetSig failed: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := randomGen.EndSession(); err != nil {
+		return fmt.Errorf("EndSession failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseNonce(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		value       string
+		allowRandom bool
+		want        []byte
+		ok          bool
+	}{
+		{"", false, nil, true},
+		{"00ff", false, []byte{0x00, 0xff}, true},
+		{"00FF", false, []byte{0x00, 0xff}, true},
+		{string(bytes.Repeat([]byte("ab"), NonceMaxBytes)), false, bytes.Repeat([]byte{0xab}, NonceMaxBytes), true},
+		{string(bytes.Repeat([]byte("ab"), NonceMaxBytes+1)), false, nil, false},
+		{"0", false, nil, false},
+		{"xyz", false, nil, false},
+		{nonceRandom, false, nil, false},
+	} {
+		got, err := parseNonce(tc.value, tc.allowRandom)
+		if (err == nil) != tc.ok || !bytes.Equal(got, tc.want) {
+			t.Errorf("%q: got %x, %v", tc.value, got, err)
+		}
+	}
+
+	a, errA := parseNonce(nonceRandom, true)
+	b, errB := parseNonce(nonceRandom, true)
+	if errA != nil || errB != nil || len(a) != NonceMaxBytes || bytes.Equal(a, b) {
+		t.Errorf("random: got %x, %v and %x, %v", a, errA, b, errB)
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		label string
+		ok    bool
+	}{
+		{"", true},
+		{"lottery 2026", true},
+		{string(bytes.Repeat([]byte("x"), ContextMaxBytes)), true},
+		{string(bytes.Repeat([]byte("x"), ContextMaxBytes+1)), false},
+	} {
+		if err := checkContext(tc.label); (err == nil) != tc.ok {
+			t.Errorf("%d bytes: got %v", len(tc.label), err)
+		}
+	}
+}
+
+// sessionCounter counts how the signature sessions of a fake TKey are
+// ended.
+type sessionCounter struct {
+	*fakeTKey
+	signed int
+	ended  int
+}
+
+func (c *sessionCounter) GetSignature() ([]byte, []byte, error) {
+	c.signed++
+	return c.fakeTKey.GetSignature()
+}
+
+func (c *sessionCounter) EndSession() error {
+	c.ended++
+	return c.fakeTKey.EndSession()
+}
+
+func TestEndSession(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		appVersion uint32
+		signed     int
+		ended      int
+	}{
+		{appVersionExtended, 1, 0},
+		{appVersionContext, 1, 0},
+		// Never waits for a touch
+		{appVersionTouch, 0, 1},
+	} {
+		c := &sessionCounter{fakeTKey: newFakeTKey(t, zeroReader{})}
+		if err := endSession(c, tc.appVersion); err != nil {
+			t.Errorf("version %d: %v", tc.appVersion, err)
+		}
+		if c.signed != tc.signed || c.ended != tc.ended {
+			t.Errorf("version %d: signed %d and ended %d sessions, want %d and %d", tc.appVersion, c.signed, c.ended, tc.signed, tc.ended)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"tkey-random-generator/randverify"
+)
+
+// sharedDevice serialises access to a TKey shared between goroutines.
+// Since the TKey hashes all data it returns until the signature is
+// fetched, it also keeps unsigned reads out of signed sessions.
+type sharedDevice struct {
+	mu      sync.Mutex
+	rg      tkeyDevice
+	nameVer bundleApp
+	pubkey  []byte
+	// dirty is true when unsigned data is in the hash on the TKey
+	dirty  bool
+	health healthTester
+}
+
+func newSharedDevice(rg tkeyDevice) (*sharedDevice, error) {
+	nameVer, err := rg.GetAppNameVersion()
+	if err != nil {
+		return nil, fmt.Errorf("GetAppNameVersion failed: %w", err)
+	}
+
+	pubkey, err := rg.GetPubkey()
+	if err != nil {
+		return nil, fmt.Errorf("GetPubkey failed: %w", err)
+	}
+
+	return &sharedDevice{
+		rg:      rg,
+		nameVer: appOf(nameVer, rg.AppDigest()),
+		pubkey:  pubkey,
+	}, nil
+}
+
+// Read fills p with random data that won't be signed. It implements
+// io.Reader.
+func (d *sharedDevice) Read(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dirty = true
+
+	return d.read(p)
+}
+
+// read fills p with random data and runs the health tests on it.
+// Must be called with mu held.
+func (d *sharedDevice) read(p []byte) (int, error) {
+	n, err := io.ReadFull(d.rg, p)
+	if err != nil {
+		return n, fmt.Errorf("could not read random data: %w", err)
+	}
+
+	if err := d.health.Check(p); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Signed returns n bytes of random data together with the signature
+// and hash the TKey made over exactly that data, keyed with nonce if
+// not nil. If the TKey is reconnected during the session it starts
+// over.
+func (d *sharedDevice) Signed(n int, nonce []byte) ([]byte, []byte, []byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for {
+		data, signature, hash, err := d.signed(n, nonce)
+		if errors.Is(err, errReconnected) {
+			le.Printf("Starting the signature session over.\n")
+			continue
+		}
+
+		return data, signature, hash, err
+	}
+}
+
+// signed does one signature session. Must be called with mu held.
+func (d *sharedDevice) signed(n int, nonce []byte) ([]byte, []byte, []byte, error) {
+	if err := d.resetSession(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if nonce != nil {
+		if err := setNonce(d.rg, d.nameVer.Version, nonce); err != nil {
+			d.dirty = true
+			return nil, nil, nil, err
+		}
+	}
+
+	data := make([]byte, n)
+	if _, err := d.read(data); err != nil {
+		d.dirty = true
+		return nil, nil, nil, err
+	}
+
+	signature, hash, err := d.rg.GetSignature()
+	if errors.Is(err, errReconnected) || errors.Is(err, ErrTouchTimeout) {
+		return nil, nil, nil, err
+	}
+	if err != nil {
+		d.dirty = true
+		return nil, nil, nil, fmt.Errorf("GetSig failed: %w", err)
+	}
+
+	// Do we compute the same hash digest as random-generator did?
+	if err := randverify.VerifyHash(hash, bytes.NewReader(data), nonce, ""); err != nil {
+		return nil, nil, nil, fmt.Errorf("hash FAILED verification: %w", err)
+	}
+
+	return data, signature, hash, nil
+}
+
+// resetSession re-inits the hash on the TKey if there is unsigned
+// data in it. Must be called with mu held.
+func (d *sharedDevice) resetSession() error {
+	if !d.dirty {
+		return nil
+	}
+
+	// After a reconnect the hash is re-inited as well
+	err := endSession(d.rg, d.nameVer.Version)
+	if err != nil && !errors.Is(err, errReconnected) {
+		return err
+	}
+	d.dirty = false
+
+	return nil
+}
+
+// Pubkey returns the public key of the TKey.
+func (d *sharedDevice) Pubkey() []byte {
+	return bytes.Clone(d.pubkey)
+}
+
+// App returns the name and version of the device app.
+func (d *sharedDevice) App() bundleApp {
+	return d.nameVer
+}
+
+// Health returns the result of the device app's self-test and the
+// state of its DRBG. Both are nil for device apps too old to report
+// them.
+func (d *sharedDevice) Health() (*SelfTestResult, *Status, error) {
+	if d.nameVer.Version < appVersionExtended {
+		return nil, nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, err := d.rg.SelfTest()
+	if err != nil {
+		return nil, nil, fmt.Errorf("SelfTest failed: %w", err)
+	}
+
+	status, err := d.rg.Status()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Status failed: %w", err)
+	}
+
+	return &result, status, nil
+}
+
+// Close re-inits the hash on the TKey and closes the connection.
+func (d *sharedDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.resetSession(); err != nil {
+		le.Printf("%v\n", err)
+	}
+
+	return d.rg.Close()
+}
@@ -0,0 +1,336 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tillitis/tkeyclient"
+)
+
+// Backoff between attempts to reconnect to the TKey.
+const (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = time.Minute
+)
+
+// errReconnected is returned by GetSignature on a supervisor when the
+// TKey was reconnected since the last signature, so that the hash
+// doesn't cover everything read in between.
+var errReconnected = errors.New("TKey was reconnected during the session")
+
+// tkeyDevice is a connection to the device app, either direct or
+// supervised.
+type tkeyDevice interface {
+	io.Reader
+	GetAppNameVersion() (*tkeyclient.NameVersion, error)
+	Firmware() *tkeyclient.NameVersion
+	AppDigest() string
+	GetPubkey() ([]byte, error)
+	GetSignature() ([]byte, []byte, error)
+	EndSession() error
+	SetNonce(nonce []byte) error
+	SelfTest() (SelfTestResult, error)
+	Status() (*Status, error)
+	Close() error
+}
+
+// supervisor keeps a connection to the TKey for long-running
+// subcommands. When the TKey is unplugged or the serial port fails it
+// detects the TKey again, reloads the app with the same USS, or
+// accepts the app if it's still running, checks that the public key is
+// the same as before and retries, backing off exponentially, until ctx
+// is done. If the public key differs it stops for good.
+type supervisor struct {
+	ctx context.Context
+	dev *deviceFlags
+
+	mu        sync.Mutex
+	rg        RandomGen
+	connected bool
+	pubkey    []byte
+	// broken is true if the TKey was reconnected since the last
+	// signature
+	broken bool
+	// err, if not nil, is why the supervisor stopped reconnecting
+	err error
+}
+
+// supervise connects to the TKey described by the flags and returns a
+// supervisor keeping it connected until ctx is done.
+func (f *deviceFlags) supervise(ctx context.Context) (*supervisor, error) {
+	// Read the USS now, so it can be reused without asking again
+	if f.hasUSS() {
+		if _, err := f.uss(); err != nil {
+			return nil, err
+		}
+	}
+
+	rg, err := f.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := rg.GetPubkey()
+	if err != nil {
+		rg.Close()
+		return nil, fmt.Errorf("GetPubkey failed: %w", err)
+	}
+
+	return &supervisor{
+		ctx:       ctx,
+		dev:       f,
+		rg:        rg,
+		connected: true,
+		pubkey:    pubkey,
+	}, nil
+}
+
+// do runs op on the TKey. If op fails and the TKey doesn't answer, it
+// reconnects and runs op again. Errors from a TKey that still answers
+// are returned as is.
+func (s *supervisor) do(op func(RandomGen) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.err != nil {
+			return s.err
+		}
+
+		if !s.connected {
+			if err := s.reconnect(); err != nil {
+				return err
+			}
+		}
+
+		err := op(s.rg)
+		if err == nil {
+			return nil
+		}
+
+		// Is it the TKey or the operation that failed?
+		if _, probeErr := s.rg.GetAppNameVersion(); probeErr == nil {
+			return err
+		}
+
+		le.Printf("Lost the TKey: %v\n", err)
+		sdNotify("STATUS=Lost the TKey, reconnecting")
+		s.rg.Close()
+		s.connected = false
+		metrics.connected.Set(0)
+	}
+}
+
+// reconnect connects to the TKey again, backing off exponentially
+// between attempts. Must be called with mu held.
+//
+// The app may still be running, for instance if only the serial port
+// failed, so it's accepted instead of loaded, even with --app. The
+// public key is derived from the app's digest and the USS, so it being
+// unchanged proves that it's the same app. If it has changed, reconnect
+// sets s.err to stop the supervisor.
+func (s *supervisor) reconnect() error {
+	backoff := reconnectMinBackoff
+
+	for attempt := 1; ; attempt++ {
+		le.Printf("Reconnecting to the TKey in %v (attempt %d)...\n", backoff, attempt)
+
+		select {
+		case <-s.ctx.Done():
+			return fmt.Errorf("not connected to the TKey: %w", context.Cause(s.ctx))
+		case <-time.After(backoff):
+		}
+
+		rg, err := s.dev.connectApp(false)
+		if err != nil {
+			le.Printf("Reconnecting failed: %v\n", err)
+			backoff = min(2*backoff, reconnectMaxBackoff)
+			continue
+		}
+
+		pubkey, err := rg.GetPubkey()
+		if err != nil {
+			rg.Close()
+			le.Printf("Reconnecting failed: GetPubkey: %v\n", err)
+			backoff = min(2*backoff, reconnectMaxBackoff)
+			continue
+		}
+
+		if !bytes.Equal(pubkey, s.pubkey) {
+			rg.Close()
+			metrics.connected.Set(0)
+			s.err = fmt.Errorf("TKey reconnected with public key %x, expected %x. Wrong TKey, app or USS?",
+				pubkey, s.pubkey)
+			sdNotify("STATUS=Wrong TKey, stopped reconnecting")
+
+			return s.err
+		}
+
+		// The same app as before, even if not loaded now
+		if rg.firmware == nil {
+			rg.firmware = s.rg.firmware
+			rg.appDigest = s.rg.appDigest
+		}
+
+		le.Printf("Reconnected to the TKey, public key unchanged.\n")
+		sdNotify("STATUS=Reconnected to the TKey")
+		s.rg = rg
+		s.connected = true
+		s.broken = true
+		metrics.reconnects.Inc()
+		metrics.connected.Set(1)
+
+		return nil
+	}
+}
+
+// Read fills p with random data. It implements io.Reader.
+func (s *supervisor) Read(p []byte) (int, error) {
+	var n int
+
+	err := s.do(func(rg RandomGen) error {
+		var err error
+		n, err = rg.Read(p)
+
+		return err
+	})
+
+	return n, err
+}
+
+func (s *supervisor) GetAppNameVersion() (*tkeyclient.NameVersion, error) {
+	var nameVer *tkeyclient.NameVersion
+
+	err := s.do(func(rg RandomGen) error {
+		var err error
+		nameVer, err = rg.GetAppNameVersion()
+
+		return err
+	})
+
+	return nameVer, err
+}
+
+// Firmware returns the firmware that loaded the device app on the
+// current connection, if known.
+func (s *supervisor) Firmware() *tkeyclient.NameVersion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rg.Firmware()
+}
+
+// AppDigest returns the digest of the device app loaded on the current
+// connection, if known.
+func (s *supervisor) AppDigest() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rg.AppDigest()
+}
+
+// GetPubkey returns the public key the TKey had when first connected,
+// which reconnecting makes sure it still has.
+func (s *supervisor) GetPubkey() ([]byte, error) {
+	return bytes.Clone(s.pubkey), nil
+}
+
+// GetSignature works like on RandomGen, but returns errReconnected,
+// after re-initing the hash, if the TKey was reconnected since the
+// last signature.
+func (s *supervisor) GetSignature() ([]byte, []byte, error) {
+	var signature, hash []byte
+
+	err := s.do(func(rg RandomGen) error {
+		var err error
+		signature, hash, err = rg.GetSignature()
+
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken {
+		s.broken = false
+		return nil, nil, errReconnected
+	}
+
+	return signature, hash, nil
+}
+
+// EndSession works like on RandomGen. A reconnect ends the session as
+// well.
+func (s *supervisor) EndSession() error {
+	err := s.do(func(rg RandomGen) error {
+		return rg.EndSession()
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.broken = false
+
+	return nil
+}
+
+// SetNonce works like on RandomGen. If the TKey is reconnected the
+// nonce is lost, which the next GetSignature reports.
+func (s *supervisor) SetNonce(nonce []byte) error {
+	return s.do(func(rg RandomGen) error {
+		return rg.SetNonce(nonce)
+	})
+}
+
+func (s *supervisor) SelfTest() (SelfTestResult, error) {
+	var result SelfTestResult
+
+	err := s.do(func(rg RandomGen) error {
+		var err error
+		result, err = rg.SelfTest()
+
+		return err
+	})
+
+	return result, err
+}
+
+func (s *supervisor) Status() (*Status, error) {
+	var status *Status
+
+	err := s.do(func(rg RandomGen) error {
+		var err error
+		status, err = rg.Status()
+
+		return err
+	})
+
+	return status, err
+}
+
+// Close closes the connection, if there is one.
+func (s *supervisor) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.connected {
+		return nil
+	}
+	s.connected = false
+	metrics.connected.Set(0)
+
+	return s.rg.Close()
+}
@@ -0,0 +1,130 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd
+// socket activation.
+const listenFDsStart = 3
+
+// activationListener returns the listener passed by systemd socket
+// activation, or nil if the process wasn't socket activated. See
+// sd_listen_fds(3).
+func activationListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// Don't pass them on to any children
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 1 {
+		return nil, fmt.Errorf("socket activated with %d sockets, expected 1", n)
+	}
+
+	f := os.NewFile(uintptr(listenFDsStart), "LISTEN_FD_3")
+	defer f.Close()
+
+	// Makes a close-on-exec copy of the descriptor
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not use socket passed by systemd: %w", err)
+	}
+
+	return ln, nil
+}
+
+// sdNotify sends state, like "READY=1", to the service manager. It
+// does nothing if not run by one. See sd_notify(3).
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+
+	// Abstract socket
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		le.Printf("Could not notify systemd: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		le.Printf("Could not notify systemd: %v\n", err)
+	}
+}
+
+// startWatchdog pings the systemd watchdog at half its interval until
+// ctx is done. It does nothing if the watchdog isn't enabled. See
+// sd_watchdog_enabled(3).
+func startWatchdog(ctx context.Context) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	interval := time.Duration(usec) * time.Microsecond / 2
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// notifyReady tells systemd that the service is up, with status as a
+// description, and starts pinging the watchdog.
+func notifyReady(ctx context.Context, status string) {
+	sdNotify("READY=1\nSTATUS=" + status)
+	startWatchdog(ctx)
+}
+
+// credentialPath returns the path of the systemd credential name. See
+// systemd.exec(5).
+func credentialPath(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY not set, use LoadCredential= in the unit")
+	}
+
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+
+	return filepath.Join(dir, name), nil
+}
@@ -0,0 +1,269 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify returns a unixgram socket standing in for the one of
+// systemd, and sets NOTIFY_SOCKET to name, or its path if empty.
+func listenNotify(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+
+	addr := name
+	if name == "" {
+		name = filepath.Join(t.TempDir(), "notify")
+		addr = name
+	} else if name[0] == '@' {
+		addr = "\x00" + name[1:]
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	return conn
+}
+
+// readNotify returns the next state sent to conn.
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(buf[:n])
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestSdNotify(t *testing.T) {
+	conn := listenNotify(t, "")
+
+	sdNotify("READY=1")
+	if got := readNotify(t, conn); got != "READY=1" {
+		t.Errorf("got %q, want READY=1", got)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestSdNotifyAbstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are only on Linux")
+	}
+
+	conn := listenNotify(t, fmt.Sprintf("@tkey-random-generator-test-%d", os.Getpid()))
+
+	sdNotify("STOPPING=1")
+	if got := readNotify(t, conn); got != "STOPPING=1" {
+		t.Errorf("got %q, want STOPPING=1", got)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestSdNotifyUnset(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	// Not run by systemd, nothing to do
+	sdNotify("READY=1")
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestNotifyReadyWatchdog(t *testing.T) {
+	conn := listenNotify(t, "")
+	t.Setenv("WATCHDOG_USEC", "20000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifyReady(ctx, "Testing")
+	if got := readNotify(t, conn); got != "READY=1\nSTATUS=Testing" {
+		t.Errorf("got %q, want READY=1 and the status", got)
+	}
+	if got := readNotify(t, conn); got != "WATCHDOG=1" {
+		t.Errorf("got %q, want WATCHDOG=1", got)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestWatchdogOtherPID(t *testing.T) {
+	conn := listenNotify(t, "")
+	t.Setenv("WATCHDOG_USEC", "1000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The watchdog is for another process
+	startWatchdog(ctx)
+
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Errorf("pinged the watchdog of another process")
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestActivationListenerNotActivated(t *testing.T) {
+	for _, tc := range []struct {
+		pid string
+		fds string
+	}{
+		{"", ""},
+		{strconv.Itoa(os.Getpid() + 1), "1"},
+		{strconv.Itoa(os.Getpid()), "0"},
+	} {
+		t.Setenv("LISTEN_PID", tc.pid)
+		t.Setenv("LISTEN_FDS", tc.fds)
+
+		ln, err := activationListener()
+		if ln != nil || err != nil {
+			t.Errorf("LISTEN_PID=%q LISTEN_FDS=%q: got %v, %v, want neither", tc.pid, tc.fds, ln, err)
+		}
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestActivationListenerSeveral(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+
+	if _, err := activationListener(); err == nil {
+		t.Errorf("socket activated with 2 sockets, want an error")
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_FDS not unset")
+	}
+}
+
+// TestActivationListener passes a listening socket as file descriptor
+// 3 to a child running TestActivationListenerChild, like systemd does.
+func TestActivationListener(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestActivationListenerChild$")
+	cmd.Env = append(os.Environ(), "TKEY_RANDOM_GENERATOR_TEST_CHILD=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "activated" {
+		t.Errorf("got %q from the child, want activated", got)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child: %v", err)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestActivationListenerChild(t *testing.T) {
+	if os.Getenv("TKEY_RANDOM_GENERATOR_TEST_CHILD") == "" {
+		t.Skip("run by TestActivationListener")
+	}
+
+	// systemd sets it after forking
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+
+	ln, err := activationListener()
+	if err != nil || ln == nil {
+		t.Fatalf("got %v, %v, want a listener", ln, err)
+	}
+	defer ln.Close()
+
+	if os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("LISTEN_PID or LISTEN_FDS not unset")
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("activated")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestCredentialPath(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"uss", filepath.Join(dir, "uss")},
+		{"", ""},
+		{"../uss", ""},
+		{"sub/uss", ""},
+		{".", ""},
+		{"..", ""},
+	} {
+		got, err := credentialPath(tc.name)
+		if got != tc.want || (err != nil) != (tc.want == "") {
+			t.Errorf("%q: got %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := credentialPath("uss"); err == nil {
+		t.Errorf("CREDENTIALS_DIRECTORY not set, want an error")
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"tkey-random-generator/randverify"
+)
+
+// The labelled lines generate -s prints after the random data.
+const (
+	labelPubkey    = "Public key"
+	labelSignature = "Signature"
+	labelNonce     = "Nonce"
+	labelContext   = "Context"
+	labelHash      = "Hash"
+)
+
+// transcript is what was parsed from the saved output of generate -s.
+// Fields not in it are nil or empty.
+type transcript struct {
+	data      []byte
+	pubkey    []byte
+	signature []byte
+	hash      []byte
+	nonce     []byte
+	context   string
+	// lines are the line numbers of the labelled lines, and of the
+	// random data under the empty label
+	lines map[string]int
+}
+
+// readTranscript reads a transcript from path, or stdin if path is
+// "-".
+func readTranscript(path string) (*transcript, error) {
+	var input []byte
+	var err error
+
+	if path == "-" {
+		input, err = io.ReadAll(os.Stdin)
+	} else {
+		input, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	return parseTranscript(input)
+}
+
+// parseTranscript parses the output of generate -s: the random data in
+// hex, possibly wrapped over several lines, followed by lines labelled
+// "Public key:", "Signature:", "Hash:" and, if used, "Nonce:" and
+// "Context:". Other lines, like messages on stderr copied from the
+// terminal together with it, are ignored.
+func parseTranscript(input []byte) (*transcript, error) {
+	t := &transcript{lines: map[string]int{}}
+
+	var data []byte
+	inData := false
+
+	for i, raw := range bytes.Split(input, []byte("\n")) {
+		lineNo := i + 1
+		line := bytes.TrimSpace(raw)
+
+		if label, _, ok := strings.Cut(string(line), ":"); ok && isTranscriptLabel(label) {
+			inData = false
+			// Keep any spaces in a context label
+			_, value, _ := strings.Cut(string(bytes.TrimRight(raw, "\r")), ":")
+			if err := t.set(label, strings.TrimPrefix(value, " "), lineNo); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(line) == 0 || !isHex(line) {
+			inData = false
+			continue
+		}
+
+		if !inData && data != nil {
+			return nil, fmt.Errorf("line %d: more random data, after the random data on line %d", lineNo, t.lines[""])
+		}
+		if data == nil {
+			t.lines[""] = lineNo
+		}
+		inData = true
+		data = append(data, line...)
+	}
+
+	if data == nil {
+		return nil, fmt.Errorf("no random data in hex found")
+	}
+
+	t.data = make([]byte, hex.DecodedLen(len(data)))
+	if _, err := hex.Decode(t.data, data); err != nil {
+		return nil, fmt.Errorf("line %d: random data: %w", t.lines[""], err)
+	}
+
+	for _, label := range []string{labelPubkey, labelSignature} {
+		if _, ok := t.lines[label]; !ok {
+			return nil, fmt.Errorf("no %q line", label+":")
+		}
+	}
+
+	return t, nil
+}
+
+func isTranscriptLabel(label string) bool {
+	switch label {
+	case labelPubkey, labelSignature, labelNonce, labelContext, labelHash:
+		return true
+	}
+
+	return false
+}
+
+// isHex returns true if line is only hex digits.
+func isHex(line []byte) bool {
+	for _, c := range line {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// set sets the field of label to value, from line lineNo.
+func (t *transcript) set(label string, value string, lineNo int) error {
+	if first, ok := t.lines[label]; ok {
+		return fmt.Errorf("line %d: %q again, first on line %d. Transcripts of --multi can't be verified, use the --json summary",
+			lineNo, label+":", first)
+	}
+	t.lines[label] = lineNo
+
+	if label == labelContext {
+		t.context = value
+		return nil
+	}
+
+	sizes := map[string]int{
+		labelPubkey:    ed25519.PublicKeySize,
+		labelSignature: ed25519.SignatureSize,
+		labelHash:      32,
+	}
+
+	b, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("line %d: %s: %w", lineNo, label, err)
+	}
+	if size, ok := sizes[label]; ok && len(b) != size {
+		return fmt.Errorf("line %d: %s is %d bytes, expected %d", lineNo, label, len(b), size)
+	}
+	if label == labelNonce && (len(b) < 1 || len(b) > NonceMaxBytes) {
+		return fmt.Errorf("line %d: %s is %d bytes, expected 1 to %d", lineNo, label, len(b), NonceMaxBytes)
+	}
+
+	switch label {
+	case labelPubkey:
+		t.pubkey = b
+	case labelSignature:
+		t.signature = b
+	case labelNonce:
+		t.nonce = b
+	case labelHash:
+		t.hash = b
+	}
+
+	return nil
+}
+
+// verify checks that the fields of t are consistent, and that the
+// public key is pubkey, the nonce nonce and the context label context,
+// for those given. It returns a description of every problem found.
+func (t *transcript) verify(pubkey []byte, nonce []byte, context string) ([]string, error) {
+	var problems []string
+	problem := func(label string, format string, a ...any) {
+		prefix := fmt.Sprintf("line %d: %s: ", t.lines[label], label)
+		problems = append(problems, prefix+fmt.Sprintf(format, a...))
+	}
+
+	if pubkey != nil && !bytes.Equal(pubkey, t.pubkey) {
+		problem(labelPubkey, "%x is not the expected public key %x", t.pubkey, pubkey)
+	}
+
+	if nonce != nil && t.nonce != nil && !bytes.Equal(nonce, t.nonce) {
+		problem(labelNonce, "%x is not the expected nonce %x", t.nonce, nonce)
+	}
+	// Check the rest against what's in the transcript
+	if t.nonce != nil {
+		nonce = t.nonce
+	}
+
+	if context != "" && t.lines[labelContext] != 0 && context != t.context {
+		problem(labelContext, "%q is not the expected context %q", t.context, context)
+	}
+	if t.lines[labelContext] != 0 {
+		context = t.context
+	}
+
+	digest, err := randverify.Sum(nonce, context, t.data)
+	if err != nil {
+		return nil, err
+	}
+	le.Printf("BLAKE2s hash: %x\n", digest)
+
+	if t.hash != nil && !bytes.Equal(t.hash, digest) {
+		problem(labelHash, "doesn't match the random data on line %d, which hashes to %x", t.lines[""], digest)
+	}
+
+	if !ed25519.Verify(t.pubkey, digest, t.signature) {
+		// Then the hash doesn't match, so what was changed?
+		signedHash := t.hash != nil && ed25519.Verify(t.pubkey, t.hash, t.signature)
+
+		switch {
+		case signedHash && nonce == nil && context == "":
+			problem(labelSignature, "valid for the hash, but not for the random data on line %d, which was changed, unless generated with a --nonce or --context not given", t.lines[""])
+		case signedHash:
+			problem(labelSignature, "valid for the hash, but not for the random data on line %d with this nonce and context, so one of them was changed", t.lines[""])
+		default:
+			problem(labelSignature, "not valid for the random data and the public key on line %d", t.lines[labelPubkey])
+		}
+	}
+
+	return problems, nil
+}
+
+// runVerifyTranscript verifies the transcript in path, against the
+// public key in filePubkey if not empty. It returns the exit code.
+func runVerifyTranscript(path string, filePubkey string, nonce []byte, context string) int {
+	var pubkey []byte
+	if filePubkey != "" {
+		var err error
+		pubkey, err = readPubkey(filePubkey)
+		if err != nil {
+			le.Printf("Error reading public key: %v\n", err)
+			return 1
+		}
+	}
+
+	t, err := readTranscript(path)
+	if err != nil {
+		le.Printf("Error reading transcript: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Public key: %x\n", t.pubkey)
+	fmt.Printf("Signature: %x\n", t.signature)
+
+	le.Printf("Verifying %d bytes of random data ...\n", len(t.data))
+	problems, err := t.verify(pubkey, nonce, context)
+	if err != nil {
+		le.Printf("Error verifying: %v\n", err)
+		return 1
+	}
+
+	for _, problem := range problems {
+		le.Printf("%s\n", problem)
+	}
+
+	if len(problems) > 0 {
+		le.Printf("Transcript FAILED verification.\n")
+		return 1
+	}
+
+	le.Printf("Signature verified.\n")
+	if pubkey == nil {
+		le.Printf("Note: the public key is the one in the transcript. Pass PUBKEY-FILE to check that it's the one of your TKey.\n")
+	}
+
+	return 0
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"tkey-random-generator/randverify"
+)
+
+// The prefixes of a value given inline instead of in a file.
+const (
+	inlineHex    = "hex:"
+	inlineBase64 = "base64:"
+)
+
+// inputName describes where arg, an input to verify, is read from, for
+// error messages.
+func inputName(arg string) string {
+	switch {
+	case arg == "-":
+		return "stdin"
+	case strings.HasPrefix(arg, inlineHex):
+		return "the inline hex: value"
+	case strings.HasPrefix(arg, inlineBase64):
+		return "the inline base64: value"
+	}
+
+	return arg
+}
+
+// isInline returns true if arg is a value given inline, not a file.
+func isInline(arg string) bool {
+	return strings.HasPrefix(arg, inlineHex) || strings.HasPrefix(arg, inlineBase64)
+}
+
+// readInput returns the contents of arg, an input to verify: a file,
+// '-' (dash) for stdin, or a value given inline prefixed with "hex:" or
+// "base64:". For an inline value, encoding is the encoding it was
+// given in, otherwise empty.
+func readInput(arg string) (input []byte, encoding string, err error) {
+	switch {
+	case arg == "-":
+		input, err = io.ReadAll(os.Stdin)
+	case strings.HasPrefix(arg, inlineHex):
+		return []byte(strings.TrimPrefix(arg, inlineHex)), randverify.EncodingHex, nil
+	case strings.HasPrefix(arg, inlineBase64):
+		return []byte(strings.TrimPrefix(arg, inlineBase64)), randverify.EncodingBase64, nil
+	default:
+		input, err = os.ReadFile(arg)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("could not read %s: %w", inputName(arg), err)
+	}
+
+	return input, "", nil
+}
+
+// openBinaryInput opens the random data in arg as is, or decoded if
+// given inline.
+func openBinaryInput(arg string) (io.ReadCloser, error) {
+	switch {
+	case arg == "-":
+		return io.NopCloser(os.Stdin), nil
+	case isInline(arg):
+		input, encoding, _ := readInput(arg)
+		data, err := decodeInput("random data", arg, input, encoding, 0)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	f, err := os.Open(arg)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", arg, err)
+	}
+
+	return f, nil
+}
+
+// readEncodedInput reads arg, see readInput, and decodes it from hex or
+// base64. what names the input in errors. If size isn't 0, it is the
+// number of bytes expected.
+func readEncodedInput(what string, arg string, size int) ([]byte, error) {
+	input, encoding, err := readInput(arg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", what, err)
+	}
+
+	return decodeInput(what, arg, input, encoding, size)
+}
+
+// readPubkey reads the Ed25519 public key in arg, see readInput.
+func readPubkey(arg string) ([]byte, error) {
+	return readEncodedInput("public key", arg, 32)
+}
+
+// decodeInput decodes input, read from arg, from encoding, or if empty
+// from hex or base64, see randverify.Decode. what names the input in
+// errors.
+func decodeInput(what string, arg string, input []byte, encoding string, size int) ([]byte, error) {
+	out, err := randverify.Decode(input, encoding, size)
+	if err != nil {
+		return nil, fmt.Errorf("%s in %s: %w", what, inputName(arg), err)
+	}
+
+	return out, nil
+}
@@ -10,6 +10,7 @@ require (
 	github.com/tillitis/tkeyclient v1.3.1
 	github.com/tillitis/tkeyutil v0.0.9
 	golang.org/x/crypto v0.40.0
+	golang.org/x/sys v0.34.0
 )
 
 require (
@@ -21,6 +22,5 @@ require (
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d // indirect
 	github.com/tadvi/systray v0.0.0-20190226123456-11a2b8fa57af // indirect
 	go.bug.st/serial v1.6.2 // indirect
-	golang.org/x/sys v0.34.0 // indirect
 	golang.org/x/term v0.33.0 // indirect
 )
@@ -40,6 +40,26 @@ void appreply(struct frame_header hdr, enum appcmd rspcode, void *buf)
 		nbytes = 128;
 		break;
 
+	case APP_RSP_GET_STATUS:
+		len = LEN_32;
+		nbytes = 32;
+		break;
+
+	case APP_RSP_GET_SELFTEST:
+	case APP_RSP_RESEED:
+	case APP_RSP_SET_RESEED_TIME:
+	case APP_RSP_SET_NONCE:
+	case APP_RSP_SET_CONTEXT:
+	case APP_RSP_END_SESSION:
+		len = LEN_4;
+		nbytes = 4;
+		break;
+
+	case APP_RSP_UNKNOWN_CMD:
+		len = LEN_1;
+		nbytes = 1;
+		break;
+
 	default:
 		qemu_puts("appreply(): Unknown response code: ");
 		qemu_puthex(rspcode);
@@ -17,11 +17,31 @@ enum appcmd {
 	APP_RSP_GET_PUBKEY      = 0x06,
 	APP_CMD_GET_SIG         = 0x07,
 	APP_RSP_GET_SIG         = 0x08,
+	APP_CMD_GET_STATUS      = 0x09,
+	APP_RSP_GET_STATUS      = 0x0a,
+	APP_CMD_GET_SELFTEST    = 0x0b,
+	APP_RSP_GET_SELFTEST    = 0x0c,
+	APP_CMD_RESEED          = 0x0d,
+	APP_RSP_RESEED          = 0x0e,
+	APP_CMD_SET_RESEED_TIME = 0x0f,
+	APP_RSP_SET_RESEED_TIME = 0x10,
+	APP_CMD_SET_NONCE       = 0x11,
+	APP_RSP_SET_NONCE       = 0x12,
+	APP_CMD_SET_CONTEXT     = 0x13,
+	APP_RSP_SET_CONTEXT     = 0x14,
+	APP_CMD_END_SESSION     = 0x15,
+	APP_RSP_END_SESSION     = 0x16,
 
 	APP_RSP_UNKNOWN_CMD     = 0xff,
 };
 // clang-format on
 
+// Statuses of APP_RSP_GET_SIG, besides STATUS_OK and STATUS_BAD, when
+// a touch is required before signing. STATUS_TOUCH_WAIT is followed by
+// another APP_RSP_GET_SIG once touched or timed out.
+#define STATUS_TOUCH_WAIT    2
+#define STATUS_TOUCH_TIMEOUT 3
+
 void appreply_nok(struct frame_header hdr);
 void appreply(struct frame_header hdr, enum appcmd rspcode, void *buf);
 
@@ -9,6 +9,8 @@
 #include "app_proto.h"
 #include "blake2s/blake2s.h"
 #include "rng.h"
+#include "selftest.h"
+#include "touch.h"
 
 // clang-format off
 static volatile	uint32_t *cdi =          (volatile uint32_t *)TK1_MMIO_TK1_CDI_FIRST;
@@ -28,11 +30,35 @@ static volatile uint32_t *app_size 	= (volatile uint32_t *) TK1_MMIO_TK1_APP_SIZ
 
 const uint8_t app_name0[4] = "tk1 ";
 const uint8_t app_name1[4] = "rand";
-const uint32_t app_version = 0x00000001;
+const uint32_t app_version = 0x00000005;
+
+// Starts the signed hash of a session with a context label, followed
+// by the length of the label and the label
+const uint8_t context_domain[] = "tkey-random-generator signature v2";
 
 // RSP_GET_RANDOM_cmdlen - (responsecode + status)
 #define RANDOM_PAYLOAD_MAXBYTES 128 - (1 + 1)
 
+// The longest nonce, which is the longest BLAKE2s key
+#define NONCE_MAXBYTES 32
+
+// The longest context label
+#define CONTEXT_MAXBYTES 64
+
+// Blink color while waiting for a touch before signing
+#define LED_TOUCH LED_GREEN
+
+// Seconds to wait for a touch before every signature, or 0 to sign
+// without one. It's set when building, so that it's part of the app's
+// digest, and of the key pair derived from it, rather than something
+// the host could turn off.
+#ifndef TOUCH_TIMEOUT
+#define TOUCH_TIMEOUT 0
+#endif
+#if TOUCH_TIMEOUT < 0 || TOUCH_TIMEOUT > 255
+#error "TOUCH_TIMEOUT needs to be in [0,255]"
+#endif
+
 int main(void)
 {
 	uint32_t stack;
@@ -47,6 +73,11 @@ int main(void)
 	uint8_t signature[64];
 	uint8_t hash[32];
 	uint8_t rand_data_generated = 0;
+	uint8_t nonce_set = 0;
+	uint8_t context_set = 0;
+	uint32_t generate_calls = 0;
+	uint32_t session_bytes = 0;
+	uint8_t selftest_failed;
 	rng_ctx rng_ctx;
 	blake2s_ctx b2s_ctx;
 
@@ -59,6 +90,10 @@ int main(void)
 	qemu_putinthex((uint32_t)&stack);
 	qemu_lf();
 
+	// Known-answer tests of the crypto we depend on. If any of them
+	// fails we refuse to serve random data or signatures.
+	selftest_failed = selftest();
+
 	// Generate public key
 	wordcpy(local_cdi, (void *)cdi, 8);
 	crypto_ed25519_key_pair(secret_key, pubkey, (uint8_t *)local_cdi);
@@ -69,7 +104,11 @@ int main(void)
 	// Init hash
 	blake2s_init(&b2s_ctx, 32, NULL, 0);
 
-	*led = LED_RED | LED_BLUE;
+	if (selftest_failed) {
+		*led = LED_RED;
+	} else {
+		*led = LED_RED | LED_BLUE;
+	}
 	for (;;) {
 		in = readbyte();
 		qemu_puts("Read byte: ");
@@ -131,6 +170,13 @@ int main(void)
 				appreply(hdr, APP_RSP_GET_RANDOM, rsp);
 				break;
 			}
+
+			if (selftest_failed) {
+				qemu_puts("Refusing, self-test failed\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_GET_RANDOM, rsp);
+				break;
+			}
 			rsp[0] = STATUS_OK;
 
 			rng_get(digest, &rng_ctx, bytes);
@@ -140,6 +186,8 @@ int main(void)
 			blake2s_update(&b2s_ctx, digest, bytes);
 
 			rand_data_generated = 1;
+			generate_calls++;
+			session_bytes += bytes;
 
 			break;
 
@@ -151,16 +199,38 @@ int main(void)
 
 		case APP_CMD_GET_SIG:
 			qemu_puts("APP_CMD_GET_SIG\n");
-			if (rand_data_generated == 0) {
+			if (rand_data_generated == 0 || selftest_failed) {
 				rsp[0] = STATUS_BAD;
 				appreply(hdr, APP_RSP_GET_SIG, rsp);
 				break;
 			}
-			rsp[0] = STATUS_OK;
 
 			// Finalize hash
 			blake2s_final(&b2s_ctx, hash);
 
+			if (TOUCH_TIMEOUT != 0) {
+				// Tell the client to wait, then reply
+				// again when touched or timed out
+				rsp[0] = STATUS_TOUCH_WAIT;
+				appreply(hdr, APP_RSP_GET_SIG, rsp);
+
+				if (!touch_wait(LED_TOUCH, TOUCH_TIMEOUT)) {
+					qemu_puts("Not touched in time\n");
+					rsp[0] = STATUS_TOUCH_TIMEOUT;
+					appreply(hdr, APP_RSP_GET_SIG, rsp);
+
+					// The session is discarded
+					blake2s_init(&b2s_ctx, 32, NULL, 0);
+					rand_data_generated = 0;
+					nonce_set = 0;
+					context_set = 0;
+					session_bytes = 0;
+
+					break;
+				}
+			}
+			rsp[0] = STATUS_OK;
+
 			// Create the Ed25519 signature of hash
 			crypto_ed25519_sign(signature, secret_key, hash,
 					    sizeof(hash));
@@ -172,7 +242,150 @@ int main(void)
 			// Re-init hash for next random generation
 			blake2s_init(&b2s_ctx, 32, NULL, 0);
 			rand_data_generated = 0;
+			nonce_set = 0;
+			context_set = 0;
+			session_bytes = 0;
+
+			break;
+
+		case APP_CMD_GET_STATUS:
+			qemu_puts("APP_CMD_GET_STATUS\n");
+			rsp[0] = STATUS_OK;
+			rsp[1] = rng_is_initialized();
+			memcpy(rsp + 2, &generate_calls, 4);
+			memcpy(rsp + 6, &rng_ctx.reseed_ctr, 4);
+			memcpy(rsp + 10, &rng_ctx.reseed_time, 4);
+			memcpy(rsp + 14, &rng_ctx.reseeds, 4);
+			memcpy(rsp + 18, &session_bytes, 4);
+			rsp[22] = TOUCH_TIMEOUT;
+			appreply(hdr, APP_RSP_GET_STATUS, rsp);
+			break;
+
+		case APP_CMD_GET_SELFTEST:
+			qemu_puts("APP_CMD_GET_SELFTEST\n");
+			rsp[0] = STATUS_OK;
+			rsp[1] = selftest_failed;
+			appreply(hdr, APP_RSP_GET_SELFTEST, rsp);
+			break;
+
+		case APP_CMD_RESEED:
+			qemu_puts("APP_CMD_RESEED\n");
+			rng_reseed(&rng_ctx);
+			rsp[0] = STATUS_OK;
+			appreply(hdr, APP_RSP_RESEED, rsp);
+			break;
+
+		case APP_CMD_SET_RESEED_TIME:
+			qemu_puts("APP_CMD_SET_RESEED_TIME\n");
+			if (hdr.len != 32) {
+				qemu_puts(
+				    "APP_CMD_SET_RESEED_TIME bad cmd length\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_RESEED_TIME, rsp);
+				break;
+			}
+
+			// cmd[1..4] is the interval in rounds, LE
+			uint32_t reseed_time;
+			memcpy(&reseed_time, cmd + 1, 4);
+			if (rng_set_reseed_time(&rng_ctx, reseed_time) != 0) {
+				qemu_puts("Reseed interval outside range\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_RESEED_TIME, rsp);
+				break;
+			}
 
+			rsp[0] = STATUS_OK;
+			appreply(hdr, APP_RSP_SET_RESEED_TIME, rsp);
+			break;
+
+		case APP_CMD_SET_NONCE:
+			qemu_puts("APP_CMD_SET_NONCE\n");
+			if (hdr.len != 128) {
+				qemu_puts("APP_CMD_SET_NONCE bad cmd length\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_NONCE, rsp);
+				break;
+			}
+
+			// cmd[1] is the length of the nonce, cmd[2..] the nonce
+			uint8_t nonce_len = cmd[1];
+			if (nonce_len < 1 || nonce_len > NONCE_MAXBYTES) {
+				qemu_puts("Nonce length outside range\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_NONCE, rsp);
+				break;
+			}
+
+			// Only before any random data in the session, so
+			// the signature proves all of it came after the
+			// nonce. Also before the context label, which
+			// re-initing the hash would lose.
+			if (rand_data_generated || nonce_set || context_set) {
+				qemu_puts("Session already started\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_NONCE, rsp);
+				break;
+			}
+
+			// Key the hash of the session with the nonce
+			blake2s_init(&b2s_ctx, 32, cmd + 2, nonce_len);
+			nonce_set = 1;
+
+			rsp[0] = STATUS_OK;
+			appreply(hdr, APP_RSP_SET_NONCE, rsp);
+			break;
+
+		case APP_CMD_SET_CONTEXT:
+			qemu_puts("APP_CMD_SET_CONTEXT\n");
+			if (hdr.len != 128) {
+				qemu_puts("APP_CMD_SET_CONTEXT bad cmd length\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_CONTEXT, rsp);
+				break;
+			}
+
+			// cmd[1] is the length of the label, cmd[2..] the
+			// label
+			uint8_t context_len = cmd[1];
+			if (context_len < 1 || context_len > CONTEXT_MAXBYTES) {
+				qemu_puts("Context length outside range\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_CONTEXT, rsp);
+				break;
+			}
+
+			// Only before any random data in the session, so
+			// the label comes first in the hash
+			if (rand_data_generated || context_set) {
+				qemu_puts("Session already started\n");
+				rsp[0] = STATUS_BAD;
+				appreply(hdr, APP_RSP_SET_CONTEXT, rsp);
+				break;
+			}
+
+			blake2s_update(&b2s_ctx, context_domain,
+				       sizeof(context_domain) - 1);
+			blake2s_update(&b2s_ctx, &context_len, 1);
+			blake2s_update(&b2s_ctx, cmd + 2, context_len);
+			context_set = 1;
+
+			rsp[0] = STATUS_OK;
+			appreply(hdr, APP_RSP_SET_CONTEXT, rsp);
+			break;
+
+		case APP_CMD_END_SESSION:
+			qemu_puts("APP_CMD_END_SESSION\n");
+			// Discard the session without signing it, and so
+			// without waiting for a touch
+			blake2s_init(&b2s_ctx, 32, NULL, 0);
+			rand_data_generated = 0;
+			nonce_set = 0;
+			context_set = 0;
+			session_bytes = 0;
+
+			rsp[0] = STATUS_OK;
+			appreply(hdr, APP_RSP_END_SESSION, rsp);
 			break;
 
 		default:
@@ -12,8 +12,6 @@
 static volatile	uint32_t *cdi =          (volatile uint32_t *)TK1_MMIO_TK1_CDI_FIRST;
 static volatile uint32_t *trng_status  = (volatile uint32_t *)TK1_MMIO_TRNG_STATUS;
 static volatile uint32_t *trng_entropy = (volatile uint32_t *)TK1_MMIO_TRNG_ENTROPY;
-
-#define RESEED_TIME 4096
 // clang-format on
 
 uint8_t rng_initalized = 0;
@@ -25,6 +23,27 @@ static uint32_t entropy_get()
 	return *trng_entropy;
 }
 
+void rng_reseed(rng_ctx *ctx)
+{
+	for (int i = 0; i < 8; i++) {
+		ctx->state[i + 8] = entropy_get();
+	}
+	ctx->reseed_ctr = 0;
+	ctx->reseeds += 1;
+}
+
+int rng_set_reseed_time(rng_ctx *ctx, uint32_t reseed_time)
+{
+	// Only allow reseeding more often than the default
+	if (reseed_time < 1 || reseed_time > RESEED_TIME) {
+		return -1;
+	}
+
+	ctx->reseed_time = reseed_time;
+
+	return 0;
+}
+
 static void rng_update(rng_ctx *ctx)
 {
 	for (int i = 0; i < 8; i++) {
@@ -39,11 +58,9 @@ static void rng_update(rng_ctx *ctx)
 	ctx->state[15] += ctx->state_ctr_lsb;
 
 	ctx->reseed_ctr += 1;
-	if (ctx->reseed_ctr == RESEED_TIME) {
-		for (int i = 0; i < 8; i++) {
-			ctx->state[i + 8] = entropy_get();
-		}
-		ctx->reseed_ctr = 0;
+	// The interval might have been lowered below the counter
+	if (ctx->reseed_ctr >= ctx->reseed_time) {
+		rng_reseed(ctx);
 	}
 }
 
@@ -60,6 +77,8 @@ void rng_init(rng_ctx *ctx)
 	ctx->state_ctr_msb = entropy_get();
 
 	ctx->reseed_ctr = 0;
+	ctx->reseed_time = RESEED_TIME;
+	ctx->reseeds = 0;
 
 	// Perform initial mixing of state.
 	blake2s_ctx b2s_ctx;
@@ -96,3 +115,8 @@ int rng_get(uint32_t *output, rng_ctx *ctx, int size)
 	qemu_hexdump((uint8_t *)output, size);
 	return 0;
 }
+
+uint8_t rng_is_initialized(void)
+{
+	return rng_initalized;
+}
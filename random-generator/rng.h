@@ -6,16 +6,23 @@
 
 #include <stdint.h>
 
+#define RESEED_TIME 4096
+
 // state context
 typedef struct {
 	uint32_t state_ctr_lsb;
 	uint32_t state_ctr_msb;
 	uint32_t reseed_ctr;
+	uint32_t reseed_time;
+	uint32_t reseeds;
 	uint32_t state[16];
 	uint32_t digest[8];
 } rng_ctx;
 
 void rng_init(rng_ctx *ctx);
 int rng_get(uint32_t *output, rng_ctx *ctx, int bytes);
+void rng_reseed(rng_ctx *ctx);
+int rng_set_reseed_time(rng_ctx *ctx, uint32_t reseed_time);
+uint8_t rng_is_initialized(void);
 
 #endif
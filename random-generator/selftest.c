@@ -0,0 +1,115 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+#include <monocypher/monocypher-ed25519.h>
+#include <stdint.h>
+#include <tkey/lib.h>
+#include <tkey/qemu_debug.h>
+
+#include "blake2s/blake2s.h"
+#include "selftest.h"
+
+// BLAKE2s-256("abc"), RFC 7693 Appendix B.
+static const uint8_t blake2s_msg[3] = "abc";
+static const uint8_t blake2s_digest[32] = {
+	0x50, 0x8c, 0x5e, 0x8c, 0x32, 0x7c, 0x14, 0xe2,
+	0xe1, 0xa7, 0x2b, 0xa3, 0x4e, 0xeb, 0x45, 0x2f,
+	0x37, 0x45, 0x8b, 0x20, 0x9e, 0xd6, 0x3a, 0x29,
+	0x4d, 0x99, 0x9b, 0x4c, 0x86, 0x67, 0x59, 0x82,
+};
+
+// Ed25519 test vector TEST 2, RFC 8032 section 7.1.
+static const uint8_t ed25519_seed[32] = {
+	0x4c, 0xcd, 0x08, 0x9b, 0x28, 0xff, 0x96, 0xda,
+	0x9d, 0xb6, 0xc3, 0x46, 0xec, 0x11, 0x4e, 0x0f,
+	0x5b, 0x8a, 0x31, 0x9f, 0x35, 0xab, 0xa6, 0x24,
+	0xda, 0x8c, 0xf6, 0xed, 0x4f, 0xb8, 0xa6, 0xfb,
+};
+static const uint8_t ed25519_pubkey[32] = {
+	0x3d, 0x40, 0x17, 0xc3, 0xe8, 0x43, 0x89, 0x5a,
+	0x92, 0xb7, 0x0a, 0xa7, 0x4d, 0x1b, 0x7e, 0xbc,
+	0x9c, 0x98, 0x2c, 0xcf, 0x2e, 0xc4, 0x96, 0x8c,
+	0xc0, 0xcd, 0x55, 0xf1, 0x2a, 0xf4, 0x66, 0x0c,
+};
+static const uint8_t ed25519_msg[1] = {0x72};
+static const uint8_t ed25519_sig[64] = {
+	0x92, 0xa0, 0x09, 0xa9, 0xf0, 0xd4, 0xca, 0xb8,
+	0x72, 0x0e, 0x82, 0x0b, 0x5f, 0x64, 0x25, 0x40,
+	0xa2, 0xb2, 0x7b, 0x54, 0x16, 0x50, 0x3f, 0x8f,
+	0xb3, 0x76, 0x22, 0x23, 0xeb, 0xdb, 0x69, 0xda,
+	0x08, 0x5a, 0xc1, 0xe4, 0x3e, 0x15, 0x99, 0x6e,
+	0x45, 0x8f, 0x36, 0x13, 0xd0, 0xf1, 0x1d, 0x8c,
+	0x38, 0x7b, 0x2e, 0xae, 0xb4, 0x30, 0x2a, 0xee,
+	0xb0, 0x0d, 0x29, 0x16, 0x12, 0xbb, 0x0c, 0x00,
+};
+
+static int selftest_blake2s(void)
+{
+	blake2s_ctx ctx;
+	uint8_t digest[32];
+
+	blake2s_init(&ctx, 32, NULL, 0);
+	blake2s_update(&ctx, blake2s_msg, sizeof(blake2s_msg));
+	blake2s_final(&ctx, digest);
+
+	return memeq(digest, blake2s_digest, sizeof(digest));
+}
+
+static int selftest_ed25519_sign(void)
+{
+	uint8_t seed[32];
+	uint8_t secret_key[64];
+	uint8_t pubkey[32];
+	uint8_t signature[64];
+
+	// crypto_ed25519_key_pair() wipes the seed
+	memcpy(seed, ed25519_seed, sizeof(seed));
+	crypto_ed25519_key_pair(secret_key, pubkey, seed);
+	if (!memeq(pubkey, ed25519_pubkey, sizeof(pubkey))) {
+		return 0;
+	}
+
+	crypto_ed25519_sign(signature, secret_key, ed25519_msg,
+			    sizeof(ed25519_msg));
+
+	return memeq(signature, ed25519_sig, sizeof(signature));
+}
+
+static int selftest_ed25519_verify(void)
+{
+	uint8_t signature[64];
+
+	if (crypto_ed25519_check(ed25519_sig, ed25519_pubkey, ed25519_msg,
+				 sizeof(ed25519_msg)) != 0) {
+		return 0;
+	}
+
+	// A corrupted signature must not verify
+	memcpy(signature, ed25519_sig, sizeof(signature));
+	signature[0] ^= 1;
+
+	return crypto_ed25519_check(signature, ed25519_pubkey, ed25519_msg,
+				    sizeof(ed25519_msg)) != 0;
+}
+
+uint8_t selftest(void)
+{
+	uint8_t failed = 0;
+
+	if (!selftest_blake2s()) {
+		qemu_puts("Self-test of BLAKE2s failed\n");
+		failed |= SELFTEST_BLAKE2S;
+	}
+
+	if (!selftest_ed25519_sign()) {
+		qemu_puts("Self-test of Ed25519 sign failed\n");
+		failed |= SELFTEST_ED25519_SIGN;
+	}
+
+	if (!selftest_ed25519_verify()) {
+		qemu_puts("Self-test of Ed25519 verify failed\n");
+		failed |= SELFTEST_ED25519_VERIFY;
+	}
+
+	return failed;
+}
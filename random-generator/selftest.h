@@ -0,0 +1,20 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+#ifndef SELFTEST_H
+#define SELFTEST_H
+
+#include <stdint.h>
+
+// Bits set in the result of selftest() for each failed test
+// clang-format off
+#define SELFTEST_BLAKE2S        (1 << 0)
+#define SELFTEST_ED25519_SIGN   (1 << 1)
+#define SELFTEST_ED25519_VERIFY (1 << 2)
+// clang-format on
+
+// Run known-answer tests of BLAKE2s and Ed25519. Returns 0 if all
+// tests pass.
+uint8_t selftest(void);
+
+#endif
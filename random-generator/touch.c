@@ -0,0 +1,57 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+#include <stdint.h>
+#include <tkey/tk1_mem.h>
+
+#include "touch.h"
+
+// clang-format off
+static volatile uint32_t *led =             (volatile uint32_t *)TK1_MMIO_TK1_LED;
+static volatile uint32_t *touch =           (volatile uint32_t *)TK1_MMIO_TOUCH_STATUS;
+static volatile uint32_t *timer =           (volatile uint32_t *)TK1_MMIO_TIMER_TIMER;
+static volatile uint32_t *timer_prescaler = (volatile uint32_t *)TK1_MMIO_TIMER_PRESCALER;
+static volatile uint32_t *timer_status =    (volatile uint32_t *)TK1_MMIO_TIMER_STATUS;
+static volatile uint32_t *timer_ctrl =      (volatile uint32_t *)TK1_MMIO_TIMER_CTRL;
+// clang-format on
+
+// The clock of the CPU, which drives the timer
+#define CPU_FREQUENCY 18000000
+
+// Timer ticks per second. The LED toggles on every tick.
+#define TICKS_PER_SECOND 4
+
+int touch_wait(uint32_t color, uint8_t timeout)
+{
+#ifdef TOUCH_EMULATE
+	(void)color;
+	(void)timeout;
+
+	return TOUCH_EMULATE;
+#else
+	uint32_t before = *led;
+	int touched = 0;
+
+	// Forget any touch before we started waiting
+	*touch = 0;
+
+	*timer_prescaler = CPU_FREQUENCY / TICKS_PER_SECOND;
+	*timer = (uint32_t)timeout * TICKS_PER_SECOND;
+	*timer_ctrl = (1 << TK1_MMIO_TIMER_CTRL_START_BIT);
+
+	while (*timer_status & (1 << TK1_MMIO_TIMER_STATUS_RUNNING_BIT)) {
+		*led = (*timer & 1) ? color : 0;
+
+		if (*touch & (1 << TK1_MMIO_TOUCH_STATUS_EVENT_BIT)) {
+			touched = 1;
+			break;
+		}
+	}
+
+	*timer_ctrl = (1 << TK1_MMIO_TIMER_CTRL_STOP_BIT);
+	*touch = 0;
+	*led = before;
+
+	return touched;
+#endif
+}
@@ -0,0 +1,17 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+#ifndef TOUCH_H
+#define TOUCH_H
+
+#include <stdint.h>
+
+// Blink the LED in color and wait at most timeout seconds for the
+// touch sensor. Returns 1 if touched, 0 on timeout.
+//
+// QEMU has no touch sensor, so when built with TOUCH_EMULATE defined
+// it doesn't wait but returns TOUCH_EMULATE instead: 1 to emulate a
+// touch, 0 to emulate no touch.
+int touch_wait(uint32_t color, uint8_t timeout);
+
+#endif
@@ -0,0 +1,196 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+// Package randsock implements the protocol used to fetch random data
+// from "tkey-random-generator serve" over a Unix domain socket, and a
+// client for it.
+//
+// A request is the number of bytes wanted as a 32 bit big endian
+// integer, at most MaxRequest. The response is a status byte followed
+// by a 32 bit big endian length and that many bytes. If the status is
+// StatusOK the bytes are the random data, otherwise they are an error
+// message. A client may send any number of requests on the same
+// connection, one at a time.
+//
+// Use Open to get random data from the daemon when it's running and
+// from somewhere else, typically a directly connected TKey, when it's
+// not:
+//
+//	r, viaDaemon, err := randsock.Open(randsock.DefaultPath(), openTKey)
+package randsock
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// MaxRequest is the largest number of bytes in one request.
+const MaxRequest = 1 << 20
+
+// Response status codes.
+const (
+	StatusOK  = 0
+	StatusErr = 1
+)
+
+// socketName is the name of the socket in the default directory.
+const socketName = "tkey-random-generator.sock"
+
+// DefaultPath returns the default path of the daemon's socket:
+// $XDG_RUNTIME_DIR/tkey-random-generator.sock if XDG_RUNTIME_DIR is
+// set, otherwise /run/tkey-random-generator.sock.
+func DefaultPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, socketName)
+	}
+
+	return filepath.Join("/run", socketName)
+}
+
+// ReadRequest reads a request from r and returns the number of bytes
+// asked for.
+func ReadRequest(r io.Reader) (int, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, fmt.Errorf("read request: %w", err)
+	}
+
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > MaxRequest {
+		return 0, fmt.Errorf("request for %d bytes is not in [1,%d]", n, MaxRequest)
+	}
+
+	return int(n), nil
+}
+
+// WriteRequest writes a request for n bytes to w.
+func WriteRequest(w io.Writer, n int) error {
+	if n < 1 || n > MaxRequest {
+		return fmt.Errorf("request for %d bytes is not in [1,%d]", n, MaxRequest)
+	}
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(n))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return fmt.Errorf("write request: %w", err)
+	}
+
+	return nil
+}
+
+// WriteResponse writes a response with status and payload to w.
+func WriteResponse(w io.Writer, status byte, payload []byte) error {
+	hdr := make([]byte, 5, 5+len(payload))
+	hdr[0] = status
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(payload)))
+
+	if _, err := w.Write(append(hdr, payload...)); err != nil {
+		return fmt.Errorf("write response: %w", err)
+	}
+
+	return nil
+}
+
+// ErrDaemon is wrapped by errors reported by the daemon.
+var ErrDaemon = errors.New("daemon error")
+
+// ReadResponse reads a response to a request for want bytes from r
+// and returns the random data.
+func ReadResponse(r io.Reader, want int) ([]byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > MaxRequest {
+		return nil, fmt.Errorf("response of %d bytes too long", n)
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	if hdr[0] != StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrDaemon, payload)
+	}
+
+	if int(n) != want {
+		return nil, fmt.Errorf("got %d bytes, asked for %d", n, want)
+	}
+
+	return payload, nil
+}
+
+// Client is a connection to the daemon.
+type Client struct {
+	conn net.Conn
+}
+
+// Dial connects to the daemon listening on the socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", path, err)
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Read fills p with random data from the daemon. It implements
+// io.Reader.
+func (c *Client) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		want := min(len(p)-n, MaxRequest)
+		if err := WriteRequest(c.conn, want); err != nil {
+			return n, err
+		}
+
+		random, err := ReadResponse(c.conn, want)
+		if err != nil {
+			return n, err
+		}
+
+		n += copy(p[n:], random)
+	}
+
+	return n, nil
+}
+
+// Close closes the connection to the daemon.
+func (c *Client) Close() error {
+	if err := c.conn.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+
+	return nil
+}
+
+// Open returns a reader of random data from the daemon listening on
+// path. If there's no daemon it calls fallback instead and returns
+// its reader, if fallback isn't nil. The second return value is true
+// if the daemon is used.
+func Open(path string, fallback func() (io.ReadCloser, error)) (io.ReadCloser, bool, error) {
+	c, err := Dial(path)
+	if err == nil {
+		return c, true, nil
+	}
+
+	if fallback == nil {
+		return nil, false, err
+	}
+
+	r, fbErr := fallback()
+	if fbErr != nil {
+		return nil, false, fmt.Errorf("no daemon (%w) and fallback failed: %w", err, fbErr)
+	}
+
+	return r, false, nil
+}
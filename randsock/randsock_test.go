@@ -0,0 +1,210 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randsock_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tkey-random-generator/randsock"
+)
+
+func TestRequest(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		n  int
+		ok bool
+	}{
+		{1, true},
+		{32, true},
+		{randsock.MaxRequest, true},
+		{0, false},
+		{-1, false},
+		{randsock.MaxRequest + 1, false},
+	} {
+		var buf bytes.Buffer
+		err := randsock.WriteRequest(&buf, tc.n)
+		if (err == nil) != tc.ok {
+			t.Errorf("write %d: got %v", tc.n, err)
+
+			continue
+		}
+		if !tc.ok {
+			continue
+		}
+
+		n, err := randsock.ReadRequest(&buf)
+		if err != nil || n != tc.n {
+			t.Errorf("read %d: got %d, %v", tc.n, n, err)
+		}
+	}
+}
+
+func TestReadRequestBad(t *testing.T) {
+	t.Parallel()
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"short":     {0, 0, 1},
+		"zero":      {0, 0, 0, 0},
+		"too large": binary.BigEndian.AppendUint32(nil, randsock.MaxRequest+1),
+	} {
+		if n, err := randsock.ReadRequest(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: got %d, want an error", name, n)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		status  byte
+		payload []byte
+		want    int
+		err     string
+	}{
+		{"ok", randsock.StatusOK, []byte("random"), 6, ""},
+		{"daemon error", randsock.StatusErr, []byte("TKey gone"), 6, "daemon error: TKey gone"},
+		{"short", randsock.StatusOK, []byte("rand"), 6, "got 4 bytes, asked for 6"},
+	} {
+		var buf bytes.Buffer
+		if err := randsock.WriteResponse(&buf, tc.status, tc.payload); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := randsock.ReadResponse(&buf, tc.want)
+		if tc.err == "" {
+			if err != nil || !bytes.Equal(got, tc.payload) {
+				t.Errorf("%s: got %q, %v", tc.name, got, err)
+			}
+
+			continue
+		}
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+		}
+		if tc.status == randsock.StatusErr && !errors.Is(err, randsock.ErrDaemon) {
+			t.Errorf("%s: %v doesn't wrap ErrDaemon", tc.name, err)
+		}
+	}
+}
+
+func TestReadResponseBad(t *testing.T) {
+	t.Parallel()
+
+	tooLong := binary.BigEndian.AppendUint32([]byte{randsock.StatusOK}, randsock.MaxRequest+1)
+	truncated := binary.BigEndian.AppendUint32([]byte{randsock.StatusOK}, 8)
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"too long":  tooLong,
+		"truncated": append(truncated, 1, 2, 3),
+	} {
+		if _, err := randsock.ReadResponse(bytes.NewReader(data), 8); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+// serve answers requests on ln with bytes of value 0xaa until the
+// listener is closed.
+func serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			for {
+				n, err := randsock.ReadRequest(conn)
+				if err != nil {
+					return
+				}
+				if err := randsock.WriteResponse(conn, randsock.StatusOK, bytes.Repeat([]byte{0xaa}, n)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "test.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln)
+
+	r, viaDaemon, err := randsock.Open(path, nil)
+	if err != nil || !viaDaemon {
+		t.Fatalf("got %v, %v, want the daemon", viaDaemon, err)
+	}
+	defer r.Close()
+
+	// More than one request's worth
+	buf := make([]byte, randsock.MaxRequest+100)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, bytes.Repeat([]byte{0xaa}, len(buf))) {
+		t.Errorf("got other data than served")
+	}
+}
+
+func TestOpenFallback(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "none.sock")
+
+	if _, _, err := randsock.Open(path, nil); err == nil {
+		t.Errorf("no daemon and no fallback, want an error")
+	}
+
+	r, viaDaemon, err := randsock.Open(path, func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("fallback")), nil
+	})
+	if err != nil || viaDaemon {
+		t.Fatalf("got %v, %v, want the fallback", viaDaemon, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "fallback" {
+		t.Errorf("got %q from the fallback", got)
+	}
+
+	failed := errors.New("no TKey")
+	_, _, err = randsock.Open(path, func() (io.ReadCloser, error) {
+		return nil, failed
+	})
+	if !errors.Is(err, failed) {
+		t.Errorf("got %v, want the error of the fallback", err)
+	}
+}
+
+//nolint:paralleltest // t.Setenv changes the environment of the process
+func TestDefaultPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := randsock.DefaultPath(); got != "/run/user/1000/tkey-random-generator.sock" {
+		t.Errorf("got %q with XDG_RUNTIME_DIR set", got)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if got := randsock.DefaultPath(); got != "/run/tkey-random-generator.sock" {
+		t.Errorf("got %q without XDG_RUNTIME_DIR", got)
+	}
+}
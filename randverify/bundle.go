@@ -0,0 +1,467 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrMissing is returned when there is less random data than signed.
+var ErrMissing = errors.New("data missing")
+
+// OpenFunc opens a file a bundle refers to by the name in the bundle,
+// like the file with the random data or the segment manifest.
+type OpenFunc func(name string) (io.ReadCloser, error)
+
+// Dir returns an OpenFunc opening names relative to dir, unless
+// absolute.
+func Dir(dir string) OpenFunc {
+	return func(name string) (io.ReadCloser, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+
+		return os.Open(name)
+	}
+}
+
+// Result is what was found verifying a bundle or a segment manifest.
+type Result struct {
+	// Bytes is the size of the output.
+	Bytes int64
+	// Pubkeys are the public keys of the signatures, in order, more
+	// than one for several TKeys. Whether they are trusted is up to
+	// the caller.
+	Pubkeys [][]byte
+	// Problems describes every hash or signature not matching. The
+	// output is verified if there are none, unless ProvenanceOnly.
+	Problems []string
+	// ProvenanceOnly is true if only that the TKeys signed the hashes
+	// in the bundle could be verified, not that the output is what
+	// they returned. That's the case with --multi combine, since what
+	// every TKey returned isn't kept. The output itself is then not
+	// verified, also without Problems.
+	ProvenanceOnly bool
+	// Verified is the number of segments verified, of an output
+	// signed in segments.
+	Verified int
+}
+
+func (r *Result) problem(format string, a ...any) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// VerifyBundle verifies the signatures in b, a bundle written by
+// generate --json, opening the files it refers to with open: of the
+// output, or of the TKey output before mixing with --mix, in segments
+// with --segment-manifest, or of each TKey with --multi. The random
+// data is read once, as a stream. Of --multi combine only the
+// provenance can be verified, see Result.ProvenanceOnly. An error is
+// returned if the bundle isn't signed or something couldn't be read.
+func VerifyBundle(b *Bundle, open OpenFunc) (*Result, error) {
+	res := &Result{Bytes: int64(b.Bytes)}
+
+	nonce, err := hex.DecodeString(b.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("bad nonce: %w", err)
+	}
+	if len(nonce) == 0 {
+		nonce = nil
+	}
+	if b.Scheme != Scheme(b.Context) {
+		res.problem("scheme %d doesn't match the context", b.Scheme)
+	}
+
+	// What was signed, the output or what the TKey returned before
+	// mixing
+	file, data := b.File, b.Data
+	if b.Mix != nil {
+		file, data = b.Mix.TKeyFile, b.Mix.TKeyData
+	}
+
+	switch {
+	case b.Multi != nil:
+		return res, verifyMulti(res, b.Multi, open, b.File, b.Data, nonce, b.Context)
+	case b.Pubkey == "":
+		return nil, fmt.Errorf("not signed")
+	}
+
+	pubkey, err := hex.DecodeString(b.Pubkey)
+	if err != nil || len(pubkey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("bad public key %q", b.Pubkey)
+	}
+	res.Pubkeys = append(res.Pubkeys, pubkey)
+
+	r, err := openData(open, file, data)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if b.SegmentManifest != "" {
+		mf, err := open(b.SegmentManifest)
+		if err != nil {
+			return nil, fmt.Errorf("could not open %s: %w", b.SegmentManifest, err)
+		}
+		defer mf.Close()
+
+		m, err := ReadSegmentManifest(mf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", b.SegmentManifest, err)
+		}
+
+		if m.Pubkey != b.Pubkey || m.Nonce != b.Nonce || m.Context != b.Context {
+			res.problem("manifest %s is not of the public key, nonce and context of the bundle", b.SegmentManifest)
+		}
+
+		segs, err := VerifySegments(m, r)
+		if err != nil {
+			return nil, err
+		}
+		res.Problems = append(res.Problems, segs.Problems...)
+
+		return res, nil
+	}
+
+	want, err := hex.DecodeString(b.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("bad hash: %w", err)
+	}
+	signature, err := hex.DecodeString(b.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("bad signature %q", b.Signature)
+	}
+
+	digest, err := Verify(pubkey, signature, r, nonce, b.Context)
+	if err != nil && !errors.Is(err, ErrSignature) {
+		return nil, err
+	}
+	if !bytes.Equal(want, digest) {
+		res.problem("%v", ErrHash)
+	}
+	if err != nil {
+		res.problem("%v", err)
+	}
+
+	return res, nil
+}
+
+// openData opens the random data in file, or if not written to a file,
+// in hex in data.
+func openData(open OpenFunc, file string, data string) (io.ReadCloser, error) {
+	if file == "" {
+		return io.NopCloser(NewHexReader(strings.NewReader(data))), nil
+	}
+
+	f, err := open(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", file, err)
+	}
+
+	return f, nil
+}
+
+// verifyMulti verifies the signature of each of several TKeys in m.
+// Striped, what a TKey signed is the ranges of the output it returned,
+// which are all hashed in one pass over the output, in file or data.
+// Combined, what a TKey returned isn't kept, so only that it signed
+// its hash is verified, and res is marked ProvenanceOnly.
+func verifyMulti(res *Result, m *MultiInfo, open OpenFunc, file string, data string, nonce []byte, label string) error {
+	res.ProvenanceOnly = m.Mode == "combine"
+
+	type device struct {
+		prefix string
+		// combined is true if only the signature of the hash can
+		// be verified
+		combined  bool
+		pubkey    []byte
+		hash      []byte
+		signature []byte
+		ranges    [][2]int64
+		// next is the index of the range read next
+		next int
+		h    hash.Hash
+	}
+
+	var devices []*device
+	fromOutput := false
+	// offset is where the output read ends
+	var offset int64
+
+	for i, info := range m.Devices {
+		// Striping little, a TKey may have returned nothing, and
+		// signed nothing
+		if m.Mode != "combine" && len(info.Ranges) == 0 && info.Signature == "" {
+			continue
+		}
+
+		d := &device{prefix: fmt.Sprintf("TKey %d (%s): ", i, info.Port)}
+		devices = append(devices, d)
+
+		var err error
+		d.pubkey, err = hex.DecodeString(info.Pubkey)
+		if err != nil || len(d.pubkey) != ed25519.PublicKeySize {
+			return fmt.Errorf("%sbad public key %q", d.prefix, info.Pubkey)
+		}
+		res.Pubkeys = append(res.Pubkeys, d.pubkey)
+
+		d.hash, err = hex.DecodeString(info.Hash)
+		if err != nil {
+			return fmt.Errorf("%sbad hash: %w", d.prefix, err)
+		}
+		d.signature, err = hex.DecodeString(info.Signature)
+		if err != nil || len(d.signature) != ed25519.SignatureSize {
+			return fmt.Errorf("%sbad signature %q", d.prefix, info.Signature)
+		}
+
+		d.h, err = NewHash(nonce, label)
+		if err != nil {
+			return err
+		}
+
+		if m.Mode == "combine" {
+			d.combined = true
+			continue
+		}
+
+		for j, r := range info.Ranges {
+			if r[0] < 0 || r[1] < 0 || j > 0 && r[0] < info.Ranges[j-1][0]+info.Ranges[j-1][1] {
+				return fmt.Errorf("%srange %d:%d not after the one before", d.prefix, r[0], r[1])
+			}
+		}
+		d.ranges = info.Ranges
+		fromOutput = true
+	}
+
+	if fromOutput {
+		r, err := openData(open, file, data)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		buf := make([]byte, 64*1024)
+
+		for {
+			n, err := r.Read(buf)
+
+			// Hand every TKey its part of what was read
+			end := offset + int64(n)
+			for _, d := range devices {
+				for ; d.next < len(d.ranges); d.next++ {
+					start, stop := d.ranges[d.next][0], d.ranges[d.next][0]+d.ranges[d.next][1]
+					if start >= end {
+						break
+					}
+					from, to := max(start, offset), min(stop, end)
+					if from < to {
+						d.h.Write(buf[from-offset : to-offset])
+					}
+					if stop > end {
+						break
+					}
+				}
+			}
+			offset = end
+
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("could not read random data: %w", err)
+			}
+		}
+	}
+
+	for _, d := range devices {
+		if d.combined {
+			if !ed25519.Verify(d.pubkey, d.hash, d.signature) {
+				res.problem("%s%v", d.prefix, ErrSignature)
+			}
+			continue
+		}
+
+		if d.next < len(d.ranges) {
+			res.problem("%s%v, the output ends at %d", d.prefix, ErrMissing, offset)
+			continue
+		}
+
+		digest := d.h.Sum(nil)
+		if !bytes.Equal(d.hash, digest) {
+			res.problem("%s%v", d.prefix, ErrHash)
+		}
+		if !ed25519.Verify(d.pubkey, digest, d.signature) {
+			res.problem("%s%v", d.prefix, ErrSignature)
+		}
+	}
+
+	return nil
+}
+
+// VerifySegments verifies every segment in m with the random data read
+// from r, the whole output in order, checking that the segments cover
+// it without gaps.
+func VerifySegments(m *SegmentManifest, r io.Reader) (*Result, error) {
+	res, pubkey, nonce, err := readSegmentKeys(m)
+	if err != nil {
+		return nil, err
+	}
+
+	// The data can't be lined up with the segments
+	if !checkSegmentOrder(res, m) {
+		return res, nil
+	}
+
+	for i, seg := range m.Segments {
+		err := VerifySegment(seg, io.LimitReader(r, seg.Length), pubkey, nonce, m.Context)
+		switch {
+		case errors.Is(err, ErrMissing):
+			res.problem("%s: %v", segmentName(i, seg), err)
+			return res, nil
+		case err != nil:
+			res.problem("%s: %v", segmentName(i, seg), err)
+		default:
+			res.Verified++
+		}
+	}
+
+	if n, err := io.Copy(io.Discard, r); err != nil {
+		return nil, fmt.Errorf("could not read random data: %w", err)
+	} else if n > 0 {
+		res.problem("%d bytes of data after the last segment", n)
+	}
+
+	return res, nil
+}
+
+// VerifySegmentRange verifies the segments in m with bytes in the
+// length bytes of the output starting at offset, reading them from r,
+// the whole output. The segments are still checked to cover all of the
+// output without gaps.
+func VerifySegmentRange(m *SegmentManifest, r io.ReaderAt, offset int64, length int64) (*Result, error) {
+	res, pubkey, nonce, err := readSegmentKeys(m)
+	if err != nil {
+		return nil, err
+	}
+
+	checkSegmentOrder(res, m)
+
+	if offset+length > m.Bytes {
+		res.problem("range ends at %d, after the end of the output at %d", offset+length, m.Bytes)
+	}
+
+	for i, seg := range m.Segments {
+		if seg.Offset >= offset+length || offset >= seg.Offset+seg.Length {
+			continue
+		}
+
+		if seg.Offset < 0 || seg.Length < 1 {
+			res.problem("%s: %v", segmentName(i, seg), ErrMissing)
+			continue
+		}
+
+		err := VerifySegment(seg, io.NewSectionReader(r, seg.Offset, seg.Length), pubkey, nonce, m.Context)
+		if err != nil {
+			res.problem("%s: %v", segmentName(i, seg), err)
+			continue
+		}
+		res.Verified++
+	}
+
+	return res, nil
+}
+
+// readSegmentKeys returns the public key and nonce of m, and a Result
+// for it.
+func readSegmentKeys(m *SegmentManifest) (*Result, []byte, []byte, error) {
+	res := &Result{Bytes: m.Bytes}
+
+	pubkey, err := hex.DecodeString(m.Pubkey)
+	if err != nil || len(pubkey) != ed25519.PublicKeySize {
+		return nil, nil, nil, fmt.Errorf("bad public key %q in manifest", m.Pubkey)
+	}
+	res.Pubkeys = append(res.Pubkeys, pubkey)
+
+	nonce, err := hex.DecodeString(m.Nonce)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("bad nonce in manifest: %w", err)
+	}
+	if m.Scheme != Scheme(m.Context) {
+		res.problem("scheme %d doesn't match the context", m.Scheme)
+	}
+
+	return res, pubkey, nonce, nil
+}
+
+// checkSegmentOrder checks that the segments in m cover the output in
+// order without gaps, adding a problem to res if not. It returns false
+// if a segment isn't where the one before it ended.
+func checkSegmentOrder(res *Result, m *SegmentManifest) bool {
+	next := int64(0)
+	for i, seg := range m.Segments {
+		if seg.Offset != next || seg.Length < 1 {
+			res.problem("%s: expected at offset %d", segmentName(i, seg), next)
+			return false
+		}
+		next = seg.Offset + seg.Length
+	}
+
+	if next != m.Bytes {
+		res.problem("segments end at %d, the manifest says %d bytes", next, m.Bytes)
+	}
+
+	return true
+}
+
+func segmentName(i int, seg Segment) string {
+	return fmt.Sprintf("Segment %d (offset %d, length %d)", i, seg.Offset, seg.Length)
+}
+
+// VerifySegment verifies the hash and signature of seg, by pubkey, with
+// the random data of the segment read from r. nonce is the one of the
+// manifest, the segment is keyed with its SegmentNonce.
+func VerifySegment(seg Segment, r io.Reader, pubkey []byte, nonce []byte, label string) error {
+	wantHash, err := hex.DecodeString(seg.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid hash in manifest")
+	}
+
+	signature, err := hex.DecodeString(seg.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature in manifest")
+	}
+
+	h, err := NewHash(SegmentNonce(nonce, seg.Offset), label)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return fmt.Errorf("could not read data: %w", err)
+	}
+	if n != seg.Length {
+		return ErrMissing
+	}
+	digest := h.Sum(nil)
+
+	if !bytes.Equal(digest, wantHash) {
+		return ErrHash
+	}
+
+	if !ed25519.Verify(pubkey, digest, signature) {
+		return ErrSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,441 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tkey-random-generator/randverify"
+)
+
+// testKey returns the key of the TKey number i of the tests.
+func testKey(i byte) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte{i + 1}, ed25519.SeedSize))
+}
+
+// testOutput returns n bytes of output.
+func testOutput(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+
+	return data
+}
+
+// sign returns the hash and signature by key of data, like the device
+// app makes them.
+func sign(t *testing.T, key ed25519.PrivateKey, data []byte, nonce []byte, label string) (string, string) {
+	t.Helper()
+
+	digest, err := randverify.Sum(nonce, label, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(digest), hex.EncodeToString(ed25519.Sign(key, digest))
+}
+
+// pubkey returns the public key of key in hex.
+func pubkey(key ed25519.PrivateKey) string {
+	return hex.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// signSegments returns the manifest of data signed by key in segments
+// of size bytes.
+func signSegments(t *testing.T, key ed25519.PrivateKey, data []byte, size int64, nonce []byte, label string) *randverify.SegmentManifest {
+	t.Helper()
+
+	m := &randverify.SegmentManifest{
+		Version:     randverify.SegmentVersion,
+		Bytes:       int64(len(data)),
+		SegmentSize: size,
+		Pubkey:      pubkey(key),
+		Nonce:       hex.EncodeToString(nonce),
+		Scheme:      randverify.Scheme(label),
+		Context:     label,
+	}
+
+	for offset := int64(0); offset < m.Bytes; offset += size {
+		end := min(offset+size, m.Bytes)
+		hash, signature := sign(t, key, data[offset:end], randverify.SegmentNonce(nonce, offset), label)
+		m.Segments = append(m.Segments, randverify.Segment{
+			Offset:    offset,
+			Length:    end - offset,
+			Hash:      hash,
+			Signature: signature,
+		})
+	}
+
+	return m
+}
+
+// files returns an OpenFunc opening the files named in fs.
+func files(fs map[string][]byte) randverify.OpenFunc {
+	return func(name string) (io.ReadCloser, error) {
+		data, ok := fs[name]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+// manifestJSON returns m as JSON.
+func manifestJSON(t *testing.T, m *randverify.SegmentManifest) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestVerifyBundle(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(0)
+	data := testOutput(1000)
+	other := append([]byte{data[0] ^ 1}, data[1:]...)
+	nonce := []byte("challenge")
+	label := "lottery 2026"
+
+	hash, signature := sign(t, key, data, nil, "")
+	hashNonce, signatureNonce := sign(t, key, data, nonce, label)
+	manifest := manifestJSON(t, signSegments(t, key, data, 300, nonce, label))
+
+	fs := files(map[string][]byte{
+		"random.bin":    data,
+		"other.bin":     other,
+		"tkey.bin":      data,
+		"manifest.json": manifest,
+	})
+
+	for _, tc := range []struct {
+		name     string
+		bundle   randverify.Bundle
+		problems []string
+		err      string
+	}{
+		{
+			name: "data",
+			bundle: randverify.Bundle{
+				Bytes: len(data), Data: hex.EncodeToString(data), Hash: hash, Signature: signature,
+				Pubkey: pubkey(key), Scheme: randverify.SchemeLegacy,
+			},
+		},
+		{
+			name: "file with nonce and context",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "random.bin", Hash: hashNonce, Signature: signatureNonce,
+				Pubkey: pubkey(key), Nonce: hex.EncodeToString(nonce),
+				Scheme: randverify.SchemeContext, Context: label,
+			},
+		},
+		{
+			name: "mixed",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "other.bin", Hash: hash, Signature: signature,
+				Pubkey: pubkey(key), Scheme: randverify.SchemeLegacy,
+				Mix: &randverify.MixInfo{Extractor: "blake2s-keyed-v1", Sources: []string{"tkey", "os"}, TKeyFile: "tkey.bin"},
+			},
+		},
+		{
+			name: "segments",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "random.bin", Pubkey: pubkey(key), Nonce: hex.EncodeToString(nonce),
+				Scheme: randverify.SchemeContext, Context: label, SegmentManifest: "manifest.json",
+			},
+		},
+		{
+			name: "segments of another nonce",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "random.bin", Pubkey: pubkey(key),
+				Scheme: randverify.SchemeContext, Context: label, SegmentManifest: "manifest.json",
+			},
+			problems: []string{"manifest manifest.json is not of the public key, nonce and context of the bundle"},
+		},
+		{
+			name: "other data",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "other.bin", Hash: hash, Signature: signature,
+				Pubkey: pubkey(key), Scheme: randverify.SchemeLegacy,
+			},
+			problems: []string{randverify.ErrHash.Error(), randverify.ErrSignature.Error()},
+		},
+		{
+			name: "other context",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "random.bin", Hash: hashNonce, Signature: signatureNonce,
+				Pubkey: pubkey(key), Nonce: hex.EncodeToString(nonce),
+				Scheme: randverify.SchemeContext, Context: "lottery 2027",
+			},
+			problems: []string{randverify.ErrHash.Error(), randverify.ErrSignature.Error()},
+		},
+		{
+			name: "wrong scheme",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "random.bin", Hash: hash, Signature: signature,
+				Pubkey: pubkey(key), Scheme: randverify.SchemeContext,
+			},
+			problems: []string{"scheme 2 doesn't match the context"},
+		},
+		{
+			name:   "not signed",
+			bundle: randverify.Bundle{Bytes: len(data), File: "random.bin"},
+			err:    "not signed",
+		},
+		{
+			name: "missing file",
+			bundle: randverify.Bundle{
+				Bytes: len(data), File: "missing.bin", Hash: hash, Signature: signature,
+				Pubkey: pubkey(key), Scheme: randverify.SchemeLegacy,
+			},
+			err: "could not open missing.bin: file does not exist",
+		},
+	} {
+		res, err := randverify.VerifyBundle(&tc.bundle, fs)
+		if tc.err != "" {
+			if err == nil || err.Error() != tc.err {
+				t.Errorf("%s: got %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if fmt.Sprint(res.Problems) != fmt.Sprint(tc.problems) {
+			t.Errorf("%s: got problems %q, want %q", tc.name, res.Problems, tc.problems)
+		}
+		if res.Bytes != int64(len(data)) || len(res.Pubkeys) != 1 || !bytes.Equal(res.Pubkeys[0], key.Public().(ed25519.PublicKey)) {
+			t.Errorf("%s: got %d bytes of %x", tc.name, res.Bytes, res.Pubkeys)
+		}
+	}
+}
+
+func TestVerifyBundleStriped(t *testing.T) {
+	t.Parallel()
+
+	data := testOutput(100)
+	nonce := []byte("challenge")
+
+	// TKey 0 returned 0-40 and 80-100, TKey 1 40-80 and TKey 2
+	// nothing
+	ranges := [][][2]int64{{{0, 40}, {80, 20}}, {{40, 40}}, nil}
+	multi := &randverify.MultiInfo{Mode: "stripe"}
+	for i, r := range ranges {
+		key := testKey(byte(i))
+		info := randverify.DeviceInfo{Port: fmt.Sprintf("/dev/ttyACM%d", i), Pubkey: pubkey(key), Ranges: r}
+
+		var returned []byte
+		for _, rng := range r {
+			returned = append(returned, data[rng[0]:rng[0]+rng[1]]...)
+		}
+		if len(returned) > 0 {
+			info.Hash, info.Signature = sign(t, key, returned, nonce, "")
+		}
+
+		multi.Devices = append(multi.Devices, info)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[50] ^= 1
+
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		problems []string
+	}{
+		{"all", data, nil},
+		{"tampered", tampered, []string{
+			"TKey 1 (/dev/ttyACM1): " + randverify.ErrHash.Error(),
+			"TKey 1 (/dev/ttyACM1): " + randverify.ErrSignature.Error(),
+		}},
+		{"truncated", data[:90], []string{
+			"TKey 0 (/dev/ttyACM0): data missing, the output ends at 90",
+		}},
+	} {
+		b := &randverify.Bundle{
+			Bytes:  len(data),
+			File:   "random.bin",
+			Nonce:  hex.EncodeToString(nonce),
+			Scheme: randverify.SchemeLegacy,
+			Multi:  multi,
+		}
+
+		res, err := randverify.VerifyBundle(b, files(map[string][]byte{"random.bin": tc.data}))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if fmt.Sprint(res.Problems) != fmt.Sprint(tc.problems) {
+			t.Errorf("%s: got problems %q, want %q", tc.name, res.Problems, tc.problems)
+		}
+		if len(res.Pubkeys) != 2 || res.ProvenanceOnly {
+			t.Errorf("%s: got %d public keys, provenance only %v", tc.name, len(res.Pubkeys), res.ProvenanceOnly)
+		}
+	}
+}
+
+func TestVerifyBundleCombined(t *testing.T) {
+	t.Parallel()
+
+	data := testOutput(100)
+	multi := &randverify.MultiInfo{Mode: "combine"}
+	for i := range 2 {
+		key := testKey(byte(i))
+		// What the TKey returned, which isn't kept
+		hash, signature := sign(t, key, testOutput(100+i), nil, "")
+		multi.Devices = append(multi.Devices, randverify.DeviceInfo{
+			Port:      fmt.Sprintf("/dev/ttyACM%d", i),
+			Pubkey:    pubkey(key),
+			Ranges:    [][2]int64{{0, 100}},
+			Hash:      hash,
+			Signature: signature,
+		})
+	}
+
+	b := &randverify.Bundle{Bytes: len(data), Data: hex.EncodeToString(data), Scheme: randverify.SchemeLegacy, Multi: multi}
+
+	res, err := randverify.VerifyBundle(b, files(nil))
+	if err != nil || len(res.Problems) > 0 || !res.ProvenanceOnly || len(res.Pubkeys) != 2 {
+		t.Errorf("got %+v, %v, want provenance only", res, err)
+	}
+
+	multi.Devices[1].Signature = multi.Devices[0].Signature
+	res, err = randverify.VerifyBundle(b, files(nil))
+	want := []string{"TKey 1 (/dev/ttyACM1): " + randverify.ErrSignature.Error()}
+	if err != nil || fmt.Sprint(res.Problems) != fmt.Sprint(want) {
+		t.Errorf("swapped signature: got %q, %v, want %q", res.Problems, err, want)
+	}
+}
+
+func TestVerifySegments(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(0)
+	data := testOutput(4*64 + 10)
+	nonce := []byte("challenge")
+
+	m := signSegments(t, key, data, 64, nonce, "")
+
+	res, err := randverify.VerifySegments(m, bytes.NewReader(data))
+	if err != nil || len(res.Problems) > 0 || res.Verified != 5 {
+		t.Errorf("got %+v, %v", res, err)
+	}
+
+	// A range within segments 1 and 2
+	res, err = randverify.VerifySegmentRange(m, bytes.NewReader(data), 100, 50)
+	if err != nil || len(res.Problems) > 0 || res.Verified != 2 {
+		t.Errorf("range: got %+v, %v", res, err)
+	}
+
+	res, err = randverify.VerifySegmentRange(m, bytes.NewReader(data), 300, 50)
+	if err != nil || len(res.Problems) != 1 || !strings.HasPrefix(res.Problems[0], "range ends at 350") {
+		t.Errorf("range after the end: got %+v, %v", res, err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		problems []string
+	}{
+		{"more data", append(append([]byte{}, data...), 1, 2), []string{"2 bytes of data after the last segment"}},
+		{"less data", data[:200], []string{"Segment 3 (offset 192, length 64): data missing"}},
+		{"tampered", append([]byte{data[0] ^ 1}, data[1:]...), []string{
+			"Segment 0 (offset 0, length 64): " + randverify.ErrHash.Error(),
+		}},
+	} {
+		res, err := randverify.VerifySegments(m, bytes.NewReader(tc.data))
+		if err != nil || fmt.Sprint(res.Problems) != fmt.Sprint(tc.problems) {
+			t.Errorf("%s: got %q, %v, want %q", tc.name, res.Problems, err, tc.problems)
+		}
+	}
+}
+
+func TestVerifySegmentsOrder(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(0)
+	data := testOutput(3 * 64)
+
+	// Swapping segments of the same length, data and signatures
+	m := signSegments(t, key, data, 64, nil, "")
+	swapped := append(append(append([]byte{}, data[64:128]...), data[:64]...), data[128:]...)
+	m.Segments[0].Hash, m.Segments[1].Hash = m.Segments[1].Hash, m.Segments[0].Hash
+	m.Segments[0].Signature, m.Segments[1].Signature = m.Segments[1].Signature, m.Segments[0].Signature
+
+	res, err := randverify.VerifySegments(m, bytes.NewReader(swapped))
+	if err != nil || res.Verified != 1 || len(res.Problems) != 2 {
+		t.Errorf("swapped: got %+v, %v", res, err)
+	}
+
+	// Leaving one out
+	m = signSegments(t, key, data, 64, nil, "")
+	m.Segments = append(m.Segments[:1], m.Segments[2:]...)
+
+	res, err = randverify.VerifySegments(m, bytes.NewReader(data))
+	want := "Segment 1 (offset 128, length 64): expected at offset 64"
+	if err != nil || res.Verified != 0 || len(res.Problems) != 1 || res.Problems[0] != want {
+		t.Errorf("gap: got %+v, %v", res, err)
+	}
+}
+
+func TestReadSegmentManifest(t *testing.T) {
+	t.Parallel()
+
+	m := signSegments(t, testKey(0), testOutput(100), 64, nil, "")
+
+	got, err := randverify.ReadSegmentManifest(bytes.NewReader(manifestJSON(t, m)))
+	if err != nil || len(got.Segments) != 2 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+
+	m.Version = 1
+	if _, err := randverify.ReadSegmentManifest(bytes.NewReader(manifestJSON(t, m))); err == nil {
+		t.Errorf("version 1, want an error")
+	}
+
+	m.Version = randverify.SegmentVersion
+	m.Segments = nil
+	if _, err := randverify.ReadSegmentManifest(bytes.NewReader(manifestJSON(t, m))); err == nil {
+		t.Errorf("no segments, want an error")
+	}
+}
+
+func TestDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "random.bin"), []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"random.bin", filepath.Join(dir, "random.bin")} {
+		f, err := randverify.Dir(dir)(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || string(got) != "data" {
+			t.Errorf("%s: got %q, %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Bundle is the JSON summary of a generate run. Binary fields are
+// hex encoded. Scheme is what the TKey signed, SchemeLegacy or
+// SchemeContext.
+type Bundle struct {
+	Bytes     int           `json:"bytes"`
+	File      string        `json:"file,omitempty"`
+	Data      string        `json:"data,omitempty"`
+	Hash      string        `json:"hash,omitempty"`
+	Signature string        `json:"signature,omitempty"`
+	Pubkey    string        `json:"pubkey,omitempty"`
+	Nonce     string        `json:"nonce,omitempty"`
+	Scheme    int           `json:"scheme,omitempty"`
+	Context   string        `json:"context,omitempty"`
+	App       App           `json:"app"`
+	Reseed    *ReseedPolicy `json:"reseed,omitempty"`
+	Mix       *MixInfo      `json:"mix,omitempty"`
+	Multi     *MultiInfo    `json:"multi,omitempty"`
+	// SegmentManifest is the manifest of the signatures, when
+	// signed in segments, instead of Hash and Signature.
+	SegmentManifest string `json:"segment_manifest,omitempty"`
+	// Interrupted is true if generate was interrupted after Bytes of
+	// the Requested bytes. What was generated is still signed.
+	Interrupted bool `json:"interrupted,omitempty"`
+	Requested   int  `json:"requested,omitempty"`
+}
+
+// App identifies the device app that produced the data.
+type App struct {
+	Name    string `json:"name"`
+	Version uint32 `json:"version"`
+	// SHA512 is the SHA-512 digest of the device app binary in hex,
+	// if loaded when connecting rather than already running.
+	SHA512 string `json:"sha512,omitempty"`
+}
+
+// ReseedPolicy is how the DRBG on the TKey was reseeded during the
+// run.
+type ReseedPolicy struct {
+	// Interval is the number of DRBG rounds between reseeds.
+	Interval uint32 `json:"interval"`
+	// Before is true if the DRBG was reseeded right before
+	// generating.
+	Before bool `json:"before"`
+}
+
+// MixInfo is how the TKey output was mixed with other sources. Hash
+// and Signature in the Bundle are of the TKey output before mixing.
+type MixInfo struct {
+	Extractor string   `json:"extractor"`
+	Sources   []string `json:"sources"`
+	// TKeyData is the TKey output before mixing, unless written to
+	// TKeyFile.
+	TKeyData string `json:"tkey_data,omitempty"`
+	TKeyFile string `json:"tkey_file,omitempty"`
+}
+
+// MultiInfo is how several TKeys were used together. App and Reseed
+// in the Bundle are of the first TKey.
+type MultiInfo struct {
+	Mode    string       `json:"mode"`
+	Devices []DeviceInfo `json:"devices"`
+}
+
+// DeviceInfo is what one of several TKeys contributed.
+type DeviceInfo struct {
+	Port   string        `json:"port"`
+	App    App           `json:"app"`
+	Reseed *ReseedPolicy `json:"reseed,omitempty"`
+	Pubkey string        `json:"pubkey"`
+	// Ranges are the offset and length of the parts of the output
+	// the TKey returned, in order. Combined, the TKey returned all of
+	// it, XORed with the others.
+	Ranges [][2]int64 `json:"ranges"`
+	// Hash and Signature are of what the TKey returned. Striped,
+	// that's the ranges of the output. Combined, it's not kept, since
+	// what all TKeys returned together gives the output.
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// SegmentVersion is the version of the segment manifest format.
+const SegmentVersion = 2
+
+// SegmentManifest is the signature manifest of a generate run signed
+// in segments. Every Segment is a signature session of its own, keyed
+// with the SegmentNonce of Nonce and its offset, and bound to the same
+// context label, if any.
+type SegmentManifest struct {
+	Version int `json:"version"`
+	// File is the file the segments are of, if written to one.
+	File        string    `json:"file,omitempty"`
+	Bytes       int64     `json:"bytes"`
+	SegmentSize int64     `json:"segment_size"`
+	Pubkey      string    `json:"pubkey"`
+	Nonce       string    `json:"nonce,omitempty"`
+	Scheme      int       `json:"scheme"`
+	Context     string    `json:"context,omitempty"`
+	Segments    []Segment `json:"segments"`
+	// Interrupted is true if generate was interrupted after Bytes of
+	// the Requested bytes.
+	Interrupted bool  `json:"interrupted,omitempty"`
+	Requested   int64 `json:"requested,omitempty"`
+}
+
+// Segment is the signature of one segment of the output.
+type Segment struct {
+	Offset    int64  `json:"offset"`
+	Length    int64  `json:"length"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// ReadBundle reads a bundle written by generate --json from r.
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	var b Bundle
+	if err := json.NewDecoder(r).Decode(&b); err != nil {
+		return nil, fmt.Errorf("could not parse bundle: %w", err)
+	}
+
+	return &b, nil
+}
+
+// ReadSegmentManifest reads a segment manifest written by generate
+// --segment-manifest from r.
+func ReadSegmentManifest(r io.Reader) (*SegmentManifest, error) {
+	var m SegmentManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("could not parse manifest: %w", err)
+	}
+
+	if m.Version != SegmentVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+
+	if len(m.Segments) == 0 {
+		return nil, fmt.Errorf("manifest has no segments")
+	}
+
+	return &m, nil
+}
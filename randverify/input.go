@@ -0,0 +1,238 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The encodings Decode can be asked for.
+const (
+	EncodingHex    = "hex"
+	EncodingBase64 = "base64"
+)
+
+// Decode decodes input from encoding, or if empty from hex or base64,
+// whichever it is. All whitespace is ignored, as is a label before a
+// colon, like the "Signature: " printed by generate. Hex is tried
+// first, since hex digits are valid base64 too, unless it doesn't give
+// size bytes. If size isn't 0, it is the number of bytes expected.
+// Errors point to the line and column of the first character that is
+// wrong, if any.
+func Decode(input []byte, encoding string, size int) ([]byte, error) {
+	// Skip a label, keeping track of the lines and columns for errors
+	// below
+	start := 0
+	if label, _, ok := bytes.Cut(input, []byte(":")); ok && isLabel(label) {
+		start = len(label) + 1
+	}
+
+	allHex := true
+	var notHex string
+	compact := make([]byte, 0, len(input)-start)
+	line, col := 1+bytes.Count(input[:start], []byte("\n")), start-bytes.LastIndexByte(input[:start], '\n')
+
+	for _, c := range input[start:] {
+		switch {
+		case c == '\n':
+			line, col = line+1, 1
+			continue
+		case isSpace(c):
+		case isHex(c):
+			compact = append(compact, c)
+		case encoding != EncodingHex && isBase64(c):
+			if allHex {
+				notHex = fmt.Sprintf("line %d, column %d: %q", line, col, c)
+			}
+			allHex = false
+			compact = append(compact, c)
+		default:
+			if encoding == "" {
+				encoding = "hex or base64"
+			}
+			if c == ':' {
+				return nil, fmt.Errorf("line %d, column %d: unexpected ':', a label is only allowed first", line, col)
+			}
+			return nil, fmt.Errorf("line %d, column %d: unexpected %q, expected %s", line, col, c, encoding)
+		}
+		col++
+	}
+
+	if len(compact) == 0 {
+		return nil, fmt.Errorf("empty")
+	}
+
+	sizeErr := func(n int) error {
+		return fmt.Errorf("is %d bytes, expected %d", n, size)
+	}
+
+	// Hex that didn't decode is reported as such, rather than as
+	// base64
+	maybeHex := allHex && encoding != EncodingBase64
+	hexErr := func() error {
+		if len(compact)%2 != 0 {
+			return fmt.Errorf("odd number of hex digits, %d", len(compact))
+		}
+		return sizeErr(len(compact) / 2)
+	}
+
+	if maybeHex && (encoding == EncodingHex || size == 0 || len(compact) == 2*size) {
+		if len(compact)%2 != 0 || size != 0 && len(compact) != 2*size {
+			return nil, hexErr()
+		}
+
+		out := make([]byte, len(compact)/2)
+		_, _ = hex.Decode(out, compact)
+
+		return out, nil
+	}
+
+	// If not base64 either, it might be hex with a typo
+	var orHex string
+	if encoding == "" && !allHex {
+		orHex = ", and not hex because of " + notHex
+	}
+
+	out, err := decodeBase64(compact)
+	switch {
+	case err != nil && maybeHex:
+		return nil, hexErr()
+	case err != nil:
+		return nil, fmt.Errorf("not valid base64: %v%s", err, orHex)
+	case size != 0 && len(out) != size && maybeHex:
+		return nil, hexErr()
+	case size != 0 && len(out) != size:
+		return nil, fmt.Errorf("is %d bytes as base64, expected %d%s", len(out), size, orHex)
+	}
+
+	return out, nil
+}
+
+// decodeBase64 decodes the standard or URL safe alphabet of base64,
+// padded or not.
+func decodeBase64(b []byte) ([]byte, error) {
+	enc := base64.StdEncoding
+	if bytes.ContainsAny(b, "-_") {
+		enc = base64.URLEncoding
+	}
+	if !bytes.HasSuffix(b, []byte("=")) {
+		enc = enc.WithPadding(base64.NoPadding)
+	}
+
+	return enc.DecodeString(string(b))
+}
+
+// isLabel returns true if label looks like the label of a line in the
+// output of generate, like "Public key".
+func isLabel(label []byte) bool {
+	label = bytes.TrimSpace(label)
+	if len(label) == 0 {
+		return false
+	}
+
+	for _, c := range label {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == ' ' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == '\v'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// isBase64 returns true if c is in the standard or URL safe alphabet of
+// base64, or padding.
+func isBase64(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '+' || c == '/' || c == '-' || c == '_' || c == '='
+}
+
+// hexReader decodes hex, ignoring whitespace.
+type hexReader struct {
+	r    *bufio.Reader
+	line int
+	col  int
+	err  error
+}
+
+// NewHexReader returns a reader decoding the random data in hex read
+// from r, like generate prints it, ignoring whitespace and line
+// breaks. Errors point to the line and column of the first character
+// that isn't hex.
+func NewHexReader(r io.Reader) io.Reader {
+	return &hexReader{r: bufio.NewReader(r), line: 1, col: 1}
+}
+
+// digit returns the next hex digit, skipping whitespace.
+func (h *hexReader) digit() (byte, error) {
+	for {
+		c, err := h.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		line, col := h.line, h.col
+		if c == '\n' {
+			h.line, h.col = h.line+1, 1
+		} else {
+			h.col++
+		}
+
+		switch {
+		case isSpace(c):
+		case '0' <= c && c <= '9':
+			return c - '0', nil
+		case 'a' <= c && c <= 'f':
+			return c - 'a' + 10, nil
+		case 'A' <= c && c <= 'F':
+			return c - 'A' + 10, nil
+		default:
+			return 0, fmt.Errorf("line %d, column %d: unexpected %q, expected hex", line, col, c)
+		}
+	}
+}
+
+func (h *hexReader) Read(p []byte) (int, error) {
+	if h.err != nil {
+		return 0, h.err
+	}
+
+	n := 0
+	for n < len(p) {
+		hi, err := h.digit()
+		if err == nil {
+			var lo byte
+			lo, err = h.digit()
+			if errors.Is(err, io.EOF) {
+				err = fmt.Errorf("odd number of hex digits")
+			}
+			if err == nil {
+				p[n] = hi<<4 | lo
+				n++
+				continue
+			}
+		}
+
+		h.err = err
+		if n > 0 {
+			return n, nil
+		}
+		return 0, err
+	}
+
+	return n, nil
+}
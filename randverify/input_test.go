@@ -0,0 +1,77 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify_test
+
+import (
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"tkey-random-generator/randverify"
+)
+
+func TestDecode(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		input    string
+		encoding string
+		size     int
+		want     string
+		err      string
+	}{
+		{"00ff", "", 0, "00ff", ""},
+		{"Signature: 00 FF\n0a\n", "", 0, "00ff0a", ""},
+		{"Public key:\n  00ff", "", 2, "00ff", ""},
+		{"AP8=", "", 0, "00ff", ""},
+		{"AP8", "", 2, "00ff", ""},
+		{"-_8=", "", 0, "fbff", ""},
+		// Too short for hex of 3 bytes, so base64
+		{"00ff", "", 3, "d347df", ""},
+		{"00ff", randverify.EncodingHex, 3, "", "is 2 bytes, expected 3"},
+		{"AP8=", randverify.EncodingBase64, 2, "00ff", ""},
+		{"AP8=", randverify.EncodingHex, 0, "", "line 1, column 2: unexpected 'P', expected hex"},
+		{"0", "", 0, "", "odd number of hex digits, 1"},
+		{"", "", 0, "", "empty"},
+		{"Signature:", "", 0, "", "empty"},
+		{"00:ff", "", 0, "", "line 1, column 3: unexpected ':', a label is only allowed first"},
+		{"00\nff!", "", 0, "", "line 2, column 3: unexpected '!', expected hex or base64"},
+		{"Hash: 00ff\n00 !", "", 0, "", "line 2, column 4: unexpected '!', expected hex or base64"},
+	} {
+		got, err := randverify.Decode([]byte(tc.input), tc.encoding, tc.size)
+		switch {
+		case tc.err != "" && (err == nil || err.Error() != tc.err):
+			t.Errorf("%q: got %x, %v, want %q", tc.input, got, err, tc.err)
+		case tc.err == "" && (err != nil || hex.EncodeToString(got) != tc.want):
+			t.Errorf("%q: got %x, %v, want %s", tc.input, got, err, tc.want)
+		}
+	}
+}
+
+func TestHexReader(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		input string
+		want  string
+		err   string
+	}{
+		{"", "", ""},
+		{"00ff", "00ff", ""},
+		{"00 FF\r\n0a\n\t1b\n", "00ff0a1b", ""},
+		{"00ff0", "00ff", "odd number of hex digits"},
+		{"00\nfx", "", "line 2, column 2: unexpected 'x', expected hex"},
+	} {
+		// A byte at a time, to carry digits between reads
+		got, err := io.ReadAll(iotest.OneByteReader(randverify.NewHexReader(strings.NewReader(tc.input))))
+		switch {
+		case tc.err != "" && (err == nil || err.Error() != tc.err):
+			t.Errorf("%q: got %v, want %q", tc.input, err, tc.err)
+		case tc.err == "" && (err != nil || hex.EncodeToString(got) != tc.want):
+			t.Errorf("%q: got %x, %v, want %s", tc.input, got, err, tc.want)
+		}
+	}
+}
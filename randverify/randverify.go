@@ -0,0 +1,172 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+// Package randverify verifies random data signed by the random
+// generator device app on a TKey, as written by tkey-random-generator,
+// without a TKey or any of the packages talking to one. It only
+// depends on the standard library and golang.org/x/crypto.
+//
+// The device app signs a BLAKE2s-256 hash of the random data, see
+// NewHash, with Ed25519. Verify checks such a signature, reading the
+// data from an io.Reader so that large outputs don't need to fit in
+// memory:
+//
+//	f, err := os.Open("random.bin")
+//	...
+//	digest, err := randverify.Verify(pubkey, signature, f, nonce, context)
+//
+// VerifyBundle verifies everything in a bundle written by generate
+// --json, and VerifySegments and VerifySegmentRange the signatures of
+// an output signed in segments. Decode parses a public key or signature in hex or base64,
+// the way they are printed or copied around.
+package randverify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+// The schemes for what the device app signs.
+const (
+	// SchemeLegacy is BLAKE2s-256 of the random data.
+	SchemeLegacy = 1
+	// SchemeContext is BLAKE2s-256 of ContextDomain, the length of
+	// the context label as one byte, the label and the random data.
+	SchemeContext = 2
+)
+
+// ContextDomain starts the signed hash of the context scheme. It's the
+// same in the device app.
+const ContextDomain = "tkey-random-generator signature v2"
+
+// SegmentNonceDomain starts what SegmentNonce hashes.
+const SegmentNonceDomain = "tkey-random-generator segment nonce v2"
+
+var (
+	// ErrHash is returned when the random data doesn't match the
+	// hash the device app reported.
+	ErrHash = errors.New("hash doesn't match the random data")
+	// ErrSignature is returned when a signature is not valid.
+	ErrSignature = errors.New("signature not valid")
+)
+
+// Scheme returns the scheme the device app signs in with the context
+// label, SchemeLegacy if empty, otherwise SchemeContext.
+func Scheme(label string) int {
+	if label == "" {
+		return SchemeLegacy
+	}
+
+	return SchemeContext
+}
+
+// NewHash returns a hash.Hash computing the hash the device app makes
+// of the random data of a signature session: BLAKE2s-256, keyed with
+// nonce if not empty, in the legacy scheme if label is empty,
+// otherwise in the context scheme.
+func NewHash(nonce []byte, label string) (hash.Hash, error) {
+	h, err := blake2s.New256(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("blake2s.New256: %w", err)
+	}
+
+	if label != "" {
+		h.Write([]byte(ContextDomain))
+		h.Write([]byte{byte(len(label))})
+		h.Write([]byte(label))
+	}
+
+	return h, nil
+}
+
+// SegmentNonce returns the nonce keying the signed hash of the segment
+// at offset of an output generated with nonce, which may be empty:
+// BLAKE2s-256 of SegmentNonceDomain, the offset as 8 bytes big endian
+// and nonce. It binds every segment to its place in the output, so
+// that segments of the same length can't be swapped.
+func SegmentNonce(nonce []byte, offset int64) []byte {
+	buf := make([]byte, 0, len(SegmentNonceDomain)+8+len(nonce))
+	buf = append(buf, SegmentNonceDomain...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(offset))
+	buf = append(buf, nonce...)
+
+	sum := blake2s.Sum256(buf)
+
+	return sum[:]
+}
+
+// Sum returns the hash of data, see NewHash.
+func Sum(nonce []byte, label string, data []byte) ([]byte, error) {
+	if len(nonce) == 0 && label == "" {
+		digest := blake2s.Sum256(data)
+		return digest[:], nil
+	}
+
+	h, err := NewHash(nonce, label)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(data)
+
+	return h.Sum(nil), nil
+}
+
+// HashReader returns the hash of what is read from r until EOF, see
+// NewHash.
+func HashReader(r io.Reader, nonce []byte, label string) ([]byte, error) {
+	h, err := NewHash(nonce, label)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, fmt.Errorf("could not read random data: %w", err)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// VerifyHash returns ErrHash if the hash of what is read from r isn't
+// hash.
+func VerifyHash(hash []byte, r io.Reader, nonce []byte, label string) error {
+	digest, err := HashReader(r, nonce, label)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(hash, digest) {
+		return ErrHash
+	}
+
+	return nil
+}
+
+// Verify verifies that signature is the Ed25519 signature by pubkey of
+// the hash of what is read from r, see NewHash. It returns the hash,
+// also if the signature isn't valid, when the error is ErrSignature.
+func Verify(pubkey []byte, signature []byte, r io.Reader, nonce []byte, label string) ([]byte, error) {
+	if len(pubkey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes, expected %d", len(pubkey), ed25519.PublicKeySize)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature is %d bytes, expected %d", len(signature), ed25519.SignatureSize)
+	}
+
+	digest, err := HashReader(r, nonce, label)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ed25519.Verify(pubkey, digest, signature) {
+		return digest, ErrSignature
+	}
+
+	return digest, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2026 - Tillitis AB
+// SPDX-License-Identifier: GPL-2.0-only
+
+package randverify_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"tkey-random-generator/randverify"
+)
+
+// testData is the random data of the known answers.
+const testData = "random data from the TKey"
+
+// hashVectors are known answers of NewHash of testData, computed with
+// Python's hashlib.blake2s.
+var hashVectors = []struct {
+	nonce string
+	label string
+	want  string
+}{
+	{"", "", "061362e76c5bbe1d9d36cfee936a9a70e7f12678d077d4461410dee4c76e3aef"},
+	{"challenge", "", "c3bcc4cd7bbdf7d348da18154d3b302efc7c18b997574c2f584ddb088cdc1374"},
+	{string(make([]byte, 32)), "", "58c8a71b642c99a1b828497705ba6c8fcf1d49762aaf703fb59d6654b05b1626"},
+	{"", "lottery 2026", "db7cb2bc07be8059d0074b9238256699d08f6b761d3b4e74ab354abda61f26f7"},
+	{"challenge", "lottery 2026", "b1da25d85f2e283beec4e14c4da169972b368b3a3a32b34c6e802ba6c7dde67d"},
+}
+
+func TestNewHash(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range hashVectors {
+		h, err := randverify.NewHash([]byte(tc.nonce), tc.label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Write([]byte(testData))
+
+		if got := hex.EncodeToString(h.Sum(nil)); got != tc.want {
+			t.Errorf("nonce %q, label %q: got %s, want %s", tc.nonce, tc.label, got, tc.want)
+		}
+
+		sum, err := randverify.Sum([]byte(tc.nonce), tc.label, []byte(testData))
+		if err != nil || hex.EncodeToString(sum) != tc.want {
+			t.Errorf("Sum, nonce %q, label %q: got %x, %v", tc.nonce, tc.label, sum, err)
+		}
+
+		sum, err = randverify.HashReader(bytes.NewReader([]byte(testData)), []byte(tc.nonce), tc.label)
+		if err != nil || hex.EncodeToString(sum) != tc.want {
+			t.Errorf("HashReader, nonce %q, label %q: got %x, %v", tc.nonce, tc.label, sum, err)
+		}
+	}
+}
+
+func TestScheme(t *testing.T) {
+	t.Parallel()
+
+	for label, want := range map[string]int{
+		"":             randverify.SchemeLegacy,
+		"lottery 2026": randverify.SchemeContext,
+	} {
+		if got := randverify.Scheme(label); got != want {
+			t.Errorf("%q: got scheme %d, want %d", label, got, want)
+		}
+	}
+}
+
+func TestNewHashLongNonce(t *testing.T) {
+	t.Parallel()
+
+	if _, err := randverify.NewHash(make([]byte, 33), ""); err == nil {
+		t.Errorf("33 byte nonce, want an error")
+	}
+}
+
+func TestSegmentNonce(t *testing.T) {
+	t.Parallel()
+
+	// Computed with Python's hashlib.blake2s
+	for _, tc := range []struct {
+		nonce  string
+		offset int64
+		want   string
+	}{
+		{"", 0, "4d9784e5b8921c4d5b2eb4f3b1530ad8a17cfdc8db8b0b18ba2cf45d4033e297"},
+		{"challenge", 4096, "87f68671296f699b72df37cb8a3822d88e38da098b4b5121b2818b5108a3be40"},
+	} {
+		if got := hex.EncodeToString(randverify.SegmentNonce([]byte(tc.nonce), tc.offset)); got != tc.want {
+			t.Errorf("nonce %q, offset %d: got %s, want %s", tc.nonce, tc.offset, got, tc.want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(0)
+	pub := key.Public().(ed25519.PublicKey)
+	data := []byte(testData)
+	nonce := []byte("challenge")
+	label := "lottery 2026"
+
+	digest, err := randverify.Sum(nonce, label, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := ed25519.Sign(key, digest)
+
+	got, err := randverify.Verify(pub, signature, bytes.NewReader(data), nonce, label)
+	if err != nil || !bytes.Equal(got, digest) {
+		t.Errorf("got %x, %v", got, err)
+	}
+	if err := randverify.VerifyHash(digest, bytes.NewReader(data), nonce, label); err != nil {
+		t.Errorf("VerifyHash: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		pubkey []byte
+		data   []byte
+		nonce  []byte
+		label  string
+	}{
+		{"other key", testKey(1).Public().(ed25519.PublicKey), data, nonce, label},
+		{"other data", pub, data[1:], nonce, label},
+		{"no nonce", pub, data, nil, label},
+		{"other context", pub, data, nonce, "lottery 2027"},
+	} {
+		got, err := randverify.Verify(tc.pubkey, signature, bytes.NewReader(tc.data), tc.nonce, tc.label)
+		if !errors.Is(err, randverify.ErrSignature) || len(got) != 32 {
+			t.Errorf("%s: got %x, %v, want ErrSignature", tc.name, got, err)
+		}
+	}
+
+	if err := randverify.VerifyHash(digest, bytes.NewReader(data[1:]), nonce, label); !errors.Is(err, randverify.ErrHash) {
+		t.Errorf("VerifyHash, other data: got %v, want ErrHash", err)
+	}
+	if _, err := randverify.Verify(pub[1:], signature, bytes.NewReader(data), nonce, label); err == nil {
+		t.Errorf("short public key, want an error")
+	}
+	if _, err := randverify.Verify(pub, signature[1:], bytes.NewReader(data), nonce, label); err == nil {
+		t.Errorf("short signature, want an error")
+	}
+}